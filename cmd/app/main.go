@@ -1,53 +1,253 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
 
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
 	"github.com/0x726f6f6b6965/bank/internal/api/router"
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc"
 	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/certs"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
 	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/logging"
+	"github.com/0x726f6f6b6965/bank/internal/tracing"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v3"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// DefaultShutdownTimeout bounds the shutdown when server.shutdown_timeout is unset
+var DefaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	godotenv.Load()
 	path := os.Getenv("CONFIG")
-	data, err := os.ReadFile(path)
+	cfg, err := config.Load(path)
 	if err != nil {
-		log.Fatal("read yaml error", err)
+		fatal("load config error", err)
+		return
+	}
+	slog.SetDefault(logging.New(os.Stdout, cfg.Log))
+	if err := setupJWT(cfg); err != nil {
+		fatal("init jwt error", err)
 		return
 	}
 
-	var cfg config.AppConfig
-	err = yaml.Unmarshal(data, &cfg)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatal("unmarshal yaml error", err)
+		fatal("init tracing error", err)
 		return
 	}
 
-	services.NewBank()
+	b, err := services.NewBank(cfg, clock.Real, ids.UUID)
+	if err != nil {
+		fatal("init bank error", err)
+		return
+	}
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.HttpPort),
-		Handler: initEngine(&cfg),
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	serveErrs := make(chan error, 2)
+
+	tlsConfig, err := setupTLS(signals, cfg.Server.TLS)
+	if err != nil {
+		fatal("init tls error", err)
+		return
+	}
+
+	engine, reloader := initEngine(cfg, b, clock.Real, ids.UUID)
+
+	var grpcServer *grpc.Server
+	if cfg.GrpcPort > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GrpcPort))
+		if err != nil {
+			fatal("grpc listen error", err)
+			return
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = rpc.NewServer(b, cfg, clock.Real, ids.UUID, reloader.Limiter(), opts...)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				serveErrs <- fmt.Errorf("grpc server: %w", err)
+			}
+		}()
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		if errors.Is(err, http.ErrServerClosed) {
-			log.Println("Server was shutdown gracefully")
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.HttpPort),
+		Handler:           engine,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		TLSConfig:         tlsConfig,
+	}
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// the certificate comes from the TLS config
+			err = server.ListenAndServeTLS("", "")
 		} else {
-			log.Fatal("Server error", err)
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErrs <- fmt.Errorf("http server: %w", err)
 		}
+	}()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	go func() {
+		running := cfg
+		for range hangups {
+			running = reload(path, running, reloader)
+		}
+	}()
+
+	code := 0
+	select {
+	case <-signals.Done():
+		slog.Info("shutdown signal received")
+	case err := <-serveErrs:
+		slog.Error("Server error", "error", err)
+		code = 1
 	}
+
+	timeout := cfg.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := shutdown(ctx, server, grpcServer, b); err != nil {
+		slog.Error("shutdown error", "error", err)
+		code = 1
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("shutdown tracing error", "error", err)
+	}
+	if code == 0 {
+		slog.Info("Server was shutdown gracefully")
+	}
+	os.Exit(code)
 }
 
-func initEngine(cfg *config.AppConfig) *gin.Engine {
+// setupJWT sets the key of the access tokens, an empty secret is only valid
+// in dev and is replaced by a random key
+func setupJWT(cfg *config.AppConfig) error {
+	secret := []byte(cfg.JWT.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, config.MinJWTSecretLen)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		slog.Warn("jwt.secret is unset, the tokens are signed with a random key and do not survive a restart")
+	}
+	utils.JwtSecretKey = secret
+	return nil
+}
+
+// setupTLS returns the TLS config of the servers, nil when they serve plain
+// text. The certificate is reloaded when its files change until ctx is done.
+func setupTLS(ctx context.Context, cfg config.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	reloader, err := certs.NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := certs.ServerConfig(cfg, reloader)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(ctx, cfg.ReloadInterval)
+	return tlsConfig, nil
+}
+
+// reload loads the config file again and applies its reloadable settings,
+// it returns the running config. An invalid file changes nothing.
+func reload(path string, cfg *config.AppConfig, reloader *router.Reloader) *config.AppConfig {
+	next, err := config.Load(path)
+	if err != nil {
+		slog.Error("reload config error", "error", err)
+		return cfg
+	}
+	running := *cfg
+	var changed, restart []string
+	for _, key := range cfg.Diff(next) {
+		if !config.Reloadable[key] {
+			restart = append(restart, key)
+			continue
+		}
+		changed = append(changed, key)
+	}
+	if len(restart) > 0 {
+		slog.Warn("config changes need a restart", "keys", restart)
+	}
+	running.Log = next.Log
+	running.Admin = next.Admin
+	running.RateLimit = next.RateLimit
+	slog.SetDefault(logging.New(os.Stdout, running.Log))
+	reloader.Reload(&running)
+	slog.Info("config reloaded", "keys", changed)
+	return &running
+}
+
+// shutdown stops taking requests, waits for the ones in flight and then
+// flushes the bank, it gives up once ctx is done
+func shutdown(ctx context.Context, server *http.Server, grpcServer *grpc.Server, b services.BankInterface) error {
+	// the event streams never finish on their own
+	b.Drain(ctx)
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+			errs = append(errs, fmt.Errorf("grpc server: %w", ctx.Err()))
+		}
+	}
+	if err := b.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("bank: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// initEngine returns the http handler of the routes serving b behind the
+// recovery and the tracing middlewares
+func initEngine(cfg *config.AppConfig, b services.BankInterface, clk clock.Clock, gen ids.Generator) (*gin.Engine, *router.Reloader) {
 	gin.SetMode(func() string {
 		if cfg.IsDevEnv() {
 			return gin.DebugMode
@@ -55,12 +255,29 @@ func initEngine(cfg *config.AppConfig) *gin.Engine {
 		return gin.ReleaseMode
 	}())
 	engine := gin.New()
-	engine.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
-		c.AbortWithStatusJSON(http.StatusOK, gin.H{
-			"code": 500,
-			"msg":  "Service internal exception!",
-		})
+	// gin trusts the forwarding headers of every peer by default, the rate
+	// limits, the login protection and the audit log would then key on an
+	// IP the client chose
+	if err := engine.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		slog.Error("trusted proxies error, the forwarding headers are ignored", "error", err)
+		engine.SetTrustedProxies(nil)
+	}
+	engine.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err interface{}) {
+		slog.ErrorContext(c, "panic recovered", "error", err, "stack", string(debug.Stack()))
+		apierr.Abort(c, apierr.ErrInternal)
 	}))
-	router.RegisterRoutes(engine)
-	return engine
+	// the span of a request is the parent of the spans of the services
+	serviceName := cfg.Tracing.ServiceName
+	if serviceName == "" {
+		serviceName = tracing.DefaultServiceName
+	}
+	engine.Use(otelgin.Middleware(serviceName))
+	reloader := router.RegisterRoutes(engine, cfg, b, clk, gen)
+	return engine, reloader
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
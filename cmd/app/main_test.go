@@ -1,24 +1,42 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/0x726f6f6b6965/bank/internal/api/router"
+	"github.com/0x726f6f6b6965/bank/internal/api"
+	"github.com/0x726f6f6b6965/bank/internal/api/openapi"
 	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
 	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/tracing"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var (
 	ctx         context.Context
-	baseURL     string
 	contentType = "application/json"
 	client      *http.Client
 )
@@ -32,22 +50,53 @@ func TestMain(m *testing.M) {
 
 func setup() {
 	ctx = context.Background()
-	services.NewBank()
-	engin := gin.Default()
 	gin.SetMode(gin.TestMode)
-	router.RegisterRoutes(engin)
-	go engin.Run(":8080")
-	baseURL = "http://localhost:8080"
-	client = &http.Client{}
+	spec, err := newSpecTransport(openapi.Spec)
+	if err != nil {
+		panic(err)
+	}
+	client = &http.Client{Transport: spec}
 	fmt.Printf("\033[1;33m%s\033[0m", "> Setup completed\n")
 }
 
+// testServer - an instance of the service with a bank of its own
+type testServer struct {
+	url string
+}
+
+// newServer starts an instance of the dev config which stops with the test
+func newServer(t *testing.T) *testServer {
+	return newServerWith(t, nil, nil)
+}
+
+// newServerWith is newServer with the clock and the IDs of the test
+func newServerWith(t *testing.T, clk clock.Clock, gen ids.Generator) *testServer {
+	cfg := &config.AppConfig{Env: config.Dev}
+	engine, _ := initEngine(cfg, newBank(t, cfg, clk, gen), clk, gen)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return &testServer{url: server.URL}
+}
+
+// newBank returns a bank of cfg which is closed with the test
+func newBank(t *testing.T, cfg *config.AppConfig, clk clock.Clock, gen ids.Generator) services.BankInterface {
+	b, err := services.NewBank(cfg, clk, gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Close(context.Background())
+	})
+	return b
+}
+
 func teardown() {
 	fmt.Printf("\033[1;33m%s\033[0m", "> Teardown completed")
 	fmt.Printf("\n")
 }
 
 func TestCreateAccount(t *testing.T) {
+	srv := newServer(t)
 	pwd := uuid.NewString()
 	req := &proto.CreateAccountRequest{
 		Password: pwd,
@@ -59,7 +108,7 @@ func TestCreateAccount(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, err := http.Post(fmt.Sprintf("%s/account/register", baseURL), contentType, bytes.NewReader(b))
+	resp, err := client.Post(fmt.Sprintf("%s/account/register", srv.url), contentType, bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,10 +135,77 @@ func TestCreateAccount(t *testing.T) {
 	}
 }
 
+func TestIsolatedServers(t *testing.T) {
+	srv, other := newServer(t), newServer(t)
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the account only exists in the bank of its server
+	b, err := json.Marshal(&proto.GetTokenRequest{Account: user.Account, Password: pwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Post(fmt.Sprintf("%s/account/nonce", other.url), contentType, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected the account to be unknown to another server")
+	}
+	if _, err := srv.getToken(user.Account, pwd); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	srv := newServerWith(t, clk, ids.NewSequence())
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Account != "00000000-0000-4000-8000-000000000001" {
+		t.Fatalf("expected the first account of the sequence, got %s", user.Account)
+	}
+	if user.CreatedAt != clk.Now().Unix() {
+		t.Fatalf("expected created at %d, got %d", clk.Now().Unix(), user.CreatedAt)
+	}
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(api.TokenTTL)
+	if code := srv.authorizedStatus(t, http.MethodGet, "/bank/balance", token); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	// the token expires without waiting for it
+	clk.Advance(time.Second)
+	resp := srv.authorizedDo(t, http.MethodGet, "/bank/balance", token, nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["error"].(string) != "TOKEN_EXPIRED" {
+		t.Fatalf("expected error %s, got %s", "TOKEN_EXPIRED", result["error"])
+	}
+}
+
 func TestGetToken(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	user, err := register(pwd, 203)
+	user, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,7 +219,7 @@ func TestGetToken(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	resp, err := http.Post(fmt.Sprintf("%s/account/nonce", baseURL), contentType, bytes.NewReader(b))
+	resp, err := client.Post(fmt.Sprintf("%s/account/nonce", srv.url), contentType, bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,20 +243,21 @@ func TestGetToken(t *testing.T) {
 }
 
 func TestGetBalance(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	user, err := register(pwd, 203)
+	user, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token
-	token, err := getToken(user.Account, pwd)
+	token, err := srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/balance", baseURL), nil)
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/balance", srv.url), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -170,15 +287,16 @@ func TestGetBalance(t *testing.T) {
 }
 
 func TestDeposit(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	user, err := register(pwd, 203)
+	user, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token
-	token, err := getToken(user.Account, pwd)
+	token, err := srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -193,7 +311,7 @@ func TestDeposit(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -216,15 +334,16 @@ func TestDeposit(t *testing.T) {
 }
 
 func TestWithdraw(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	user, err := register(pwd, 203)
+	user, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token
-	token, err := getToken(user.Account, pwd)
+	token, err := srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -239,7 +358,7 @@ func TestWithdraw(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -264,21 +383,22 @@ func TestWithdraw(t *testing.T) {
 }
 
 func TestTransaction(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	from, err := register(pwd, 203)
+	from, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	pwd2 := uuid.NewString()
-	to, err := register(pwd2, 10)
+	to, err := srv.register(pwd2, 10)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token
-	token, err := getToken(from.Account, pwd)
+	token, err := srv.getToken(from.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -294,7 +414,7 @@ func TestTransaction(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -318,28 +438,29 @@ func TestTransaction(t *testing.T) {
 }
 
 func TestGetTransactions(t *testing.T) {
+	srv := newServer(t)
 	// register
 	pwd := uuid.NewString()
-	user, err := register(pwd, 203)
+	user, err := srv.register(pwd, 203)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// register2
 	pwd2 := uuid.NewString()
-	user2, err := register(pwd2, 305)
+	user2, err := srv.register(pwd2, 305)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token
-	token, err := getToken(user.Account, pwd)
+	token, err := srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// get token2
-	token2, err := getToken(user2.Account, pwd2)
+	token2, err := srv.getToken(user2.Account, pwd2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -356,7 +477,7 @@ func TestGetTransactions(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -374,7 +495,7 @@ func TestGetTransactions(t *testing.T) {
 		Amount: 100,
 	}
 
-	token, err = getToken(user.Account, pwd)
+	token, err = srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -383,7 +504,7 @@ func TestGetTransactions(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -401,7 +522,7 @@ func TestGetTransactions(t *testing.T) {
 		Amount: 10,
 	}
 
-	token, err = getToken(user.Account, pwd)
+	token, err = srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -410,7 +531,7 @@ func TestGetTransactions(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", baseURL), bytes.NewReader(b))
+	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -423,11 +544,11 @@ func TestGetTransactions(t *testing.T) {
 	}
 
 	// get transactions
-	token, err = getToken(user.Account, pwd)
+	token, err = srv.getToken(user.Account, pwd)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/transactions", baseURL), nil)
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/transactions", srv.url), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -454,7 +575,7 @@ func TestGetTransactions(t *testing.T) {
 	}
 
 	// get transactions2
-	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/transactions", baseURL), nil)
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/bank/transactions", srv.url), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -477,7 +598,1074 @@ func TestGetTransactions(t *testing.T) {
 	}
 }
 
-func register(pwd string, balance int) (*proto.User, error) {
+func TestLogout(t *testing.T) {
+	srv := newServer(t)
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 203)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get tokens
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logout
+	if code := srv.authorizedStatus(t, http.MethodPost, "/account/logout", token); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := srv.authorizedStatus(t, http.MethodGet, "/bank/balance", token); code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := srv.authorizedStatus(t, http.MethodGet, "/bank/balance", token2); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	// logout all
+	if code := srv.authorizedStatus(t, http.MethodPost, "/account/logout/all", token2); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := srv.authorizedStatus(t, http.MethodGet, "/bank/balance", token2); code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	srv := newServer(t)
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := srv.authorizedDo(t, http.MethodPost, "/account/password", token,
+		&proto.ChangePasswordRequest{OldPassword: pwd, NewPassword: uuid.NewString()})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// every token issued before the change is revoked
+	for _, tk := range []string{token, other} {
+		if code := srv.authorizedStatus(t, http.MethodGet, "/bank/balance", tk); code != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, code)
+		}
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := newServer(t)
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get token
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// withdraw more than the balance
+	body := &proto.TransactionRequest{
+		Action: proto.TransactionActionWithdraw,
+		From:   user.Account,
+		Amount: 100,
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnprocessableEntity, resp.StatusCode)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["code"].(float64) != http.StatusUnprocessableEntity {
+		t.Fatalf("expected code %d, got %v", http.StatusUnprocessableEntity, result["code"])
+	}
+	if result["error"].(string) != "INSUFFICIENT_BALANCE" {
+		t.Fatalf("expected error %s, got %s", "INSUFFICIENT_BALANCE", result["error"])
+	}
+
+	// missing token
+	resp, err = client.Get(fmt.Sprintf("%s/bank/balance", srv.url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	result = make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["error"].(string) != "INVALID_TOKEN" {
+		t.Fatalf("expected error %s, got %s", "INVALID_TOKEN", result["error"])
+	}
+}
+
+func TestProblemResponse(t *testing.T) {
+	srv := newServer(t)
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get token
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deposit with a from account and without an amount
+	body := &proto.TransactionRequest{
+		Action: proto.TransactionActionDeposit,
+		From:   user.Account,
+		To:     user.Account,
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bank/transfer", srv.url), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/problem+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected content type %s, got %s", "application/problem+json", resp.Header.Get("Content-Type"))
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["status"].(float64) != http.StatusBadRequest || result["instance"].(string) != "/bank/transfer" {
+		t.Fatalf("unexpected problem %v", result)
+	}
+	fields := map[string]bool{}
+	for _, e := range result["errors"].([]interface{}) {
+		fields[e.(map[string]interface{})["field"].(string)] = true
+	}
+	if !fields["from"] || !fields["amount"] || len(fields) != 2 {
+		t.Fatalf("expected errors of from and amount, got %v", result["errors"])
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	cfg := &config.AppConfig{Env: config.Dev}
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	engine.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["error"].(string) != "INTERNAL_ERROR" || result["message"] == nil {
+		t.Fatalf("unexpected envelope %v", result)
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	cfg := &config.AppConfig{Env: config.Dev}
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+
+	cases := []struct {
+		method string
+		path   string
+		accept string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/v2/accounts", "", http.StatusNotFound, "NOT_FOUND"},
+		{http.MethodDelete, "/healthz", "", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+		{http.MethodGet, "/v2/accounts", "application/problem+json", http.StatusNotFound, "NOT_FOUND"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		engine.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("expected status code %d, got %d", c.status, w.Code)
+		}
+		result := make(map[string]interface{})
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		code := result["error"]
+		if c.accept != "" {
+			code = result["code"]
+		}
+		if code != c.code {
+			t.Fatalf("expected error %s, got %v", c.code, result)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	srv := newServer(t)
+	// the ID of the client is kept
+	req, err := http.NewRequest(http.MethodGet, srv.url+"/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "test-request-1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-ID"); id != "test-request-1" {
+		t.Fatalf("expected request id %s, got %s", "test-request-1", id)
+	}
+
+	// an invalid one is replaced
+	req.Header.Set("X-Request-ID", "forged\tline")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := uuid.Parse(resp.Header.Get("X-Request-ID")); err != nil {
+		t.Fatalf("expected a generated request id, got %s", resp.Header.Get("X-Request-ID"))
+	}
+
+	// errors carry one too
+	resp, err = client.Get(srv.url + "/bank/balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("X-Request-ID") == "" {
+		t.Fatalf("expected status code %d with a request id, got %d %q", http.StatusUnauthorized, resp.StatusCode, resp.Header.Get("X-Request-ID"))
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(tracing.Propagator())
+	cfg := &config.AppConfig{Env: config.Dev}
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+
+	body, err := json.Marshal(&proto.CreateAccountRequest{
+		Password: uuid.NewString(),
+		Name:     uuid.NewString(),
+		Balance:  100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/account/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, ok := spans["/account/register"]
+	if !ok {
+		t.Fatalf("expected a span of the route, got %v", spans)
+	}
+	if id := server.SpanContext().TraceID().String(); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace of the caller, got %s", id)
+	}
+	service, ok := spans["bank.CreateAccount"]
+	if !ok || service.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("expected a span of the service below the route, got %v", spans)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &config.AppConfig{
+		Env: config.Dev,
+		RateLimit: config.RateLimitConfig{
+			Auth: config.RateLimitRule{Requests: 2, Period: time.Hour},
+		},
+	}
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	body, err := json.Marshal(&proto.CreateAccountRequest{Password: uuid.NewString(), Name: uuid.NewString(), Balance: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := client.Post(server.URL+"/account/register", contentType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d of request %d, got %d", expected, i+1, resp.StatusCode)
+		}
+		if expected == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "1800" {
+			t.Fatalf("expected Retry-After %s, got %q", "1800", resp.Header.Get("Retry-After"))
+		}
+	}
+
+	// the read group has no rule
+	resp, err := client.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	body, err := json.Marshal(&proto.CreateAccountRequest{Password: uuid.NewString(), Name: uuid.NewString(), Balance: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// register sends a request from another X-Forwarded-For every time
+	register := func(url string, i int) int {
+		req, err := http.NewRequest(http.MethodPost, url+"/account/register", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	rule := config.RateLimitConfig{Auth: config.RateLimitRule{Requests: 1, Period: time.Hour}}
+
+	// the header of an untrusted peer is ignored
+	cfg := &config.AppConfig{Env: config.Dev, RateLimit: rule}
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		if code := register(server.URL, i); code != expected {
+			t.Fatalf("expected status code %d of request %d, got %d", expected, i+1, code)
+		}
+	}
+
+	// a trusted proxy names the client
+	cfg = &config.AppConfig{Env: config.Dev, RateLimit: rule, Server: config.ServerConfig{TrustedProxies: []string{"127.0.0.1"}}}
+	engine, _ = initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	proxied := httptest.NewServer(engine)
+	defer proxied.Close()
+	for i := 0; i < 3; i++ {
+		if code := register(proxied.URL, i); code != http.StatusOK {
+			t.Fatalf("expected status code %d of request %d, got %d", http.StatusOK, i+1, code)
+		}
+	}
+}
+
+func TestLoginProtectionForwardedFor(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	cfg := &config.AppConfig{
+		Env: config.Dev,
+		Lockout: config.LockoutConfig{
+			MaxAttempts:   10,
+			IPMaxAttempts: 2,
+			BaseDelay:     time.Second,
+			MaxDelay:      time.Second,
+			LockDuration:  time.Hour,
+		},
+	}
+	engine, _ := initEngine(cfg, newBank(t, cfg, clk, nil), clk, nil)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	srv := &testServer{url: server.URL}
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// login sends a request from another X-Forwarded-For every time
+	login := func(i int, account, pwd string) *http.Response {
+		body, err := json.Marshal(&proto.GetTokenRequest{Account: account, Password: pwd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/account/nonce", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	// the failures spread over the accounts still count against the IP
+	for i := 0; i < 2; i++ {
+		clk.Advance(time.Second)
+		resp := login(i, uuid.NewString(), pwd)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+
+	resp := login(2, user.Account, pwd)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["error"].(string) != "TOO_MANY_ATTEMPTS" {
+		t.Fatalf("expected error %s, got %s", "TOO_MANY_ATTEMPTS", result["error"])
+	}
+}
+
+func TestV1Routes(t *testing.T) {
+	srv := newServer(t)
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPwd := uuid.NewString()
+	other, err := srv.register(otherPwd, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deposit
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/deposits", user.Account), token, &proto.DepositRequest{Amount: 50})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		t.Fatalf("expected a location header")
+	}
+
+	// the created transaction
+	resp = srv.authorizedDo(t, http.MethodGet, location, token, nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if tx := result["data"].(map[string]interface{}); tx["to"].(string) != user.Account || tx["amount"].(float64) != 50 {
+		t.Fatalf("unexpected transaction %v", tx)
+	}
+
+	// only the parties see a transaction
+	otherToken, err := srv.getToken(other.Account, otherPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := srv.authorizedStatus(t, http.MethodGet, location, otherToken); code != http.StatusNotFound {
+		t.Fatalf("expected status code %d, got %d", http.StatusNotFound, code)
+	}
+
+	// transfer
+	token, err = srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/transfers", user.Account), token, &proto.TransferRequest{To: other.Account, Amount: 30})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// only the own account
+	resp = srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/withdrawals", other.Account), token, &proto.WithdrawalRequest{Amount: 1})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status code %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// the legacy route is deprecated
+	resp = srv.authorizedDo(t, http.MethodGet, "/bank/balance", token, nil)
+	defer resp.Body.Close()
+	if resp.Header.Get("Deprecation") != "true" {
+		t.Fatalf("expected deprecation header, got %q", resp.Header.Get("Deprecation"))
+	}
+	if code := srv.authorizedStatus(t, http.MethodGet, fmt.Sprintf("/v1/accounts/%s/balance", user.Account), token); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := newServer(t)
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deposit twice
+	for i := 0; i < 2; i++ {
+		token, err := srv.getToken(user.Account, pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/deposits", user.Account), token, &proto.DepositRequest{Amount: 10})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	// the stream replays both deposits
+	events := srv.readEvents(t, token, "", 2)
+	if events[0].Balance != 110 || events[1].Balance != 120 {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	// resume after the first one
+	events = srv.readEvents(t, token, fmt.Sprint(events[0].ID), 1)
+	if events[0].Balance != 120 || events[0].Transaction.Amount != 10 {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
+
+// readEvents reads n events of the stream of the token
+func (srv *testServer) readEvents(t *testing.T, token, lastEventID string, n int) []proto.Event {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, fmt.Sprintf("%s/bank/events", srv.url), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	var events []proto.Event
+	scanner := bufio.NewScanner(resp.Body)
+	var id string
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "data:"):
+			var event proto.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(event.ID) != id {
+				t.Fatalf("expected event id %s, got %d", id, event.ID)
+			}
+			events = append(events, event)
+		}
+	}
+	if len(events) != n {
+		t.Fatalf("expected %d events, got %d: %v", n, len(events), scanner.Err())
+	}
+	return events
+}
+
+func TestWebhooks(t *testing.T) {
+	// the receiver listens on the loopback
+	cfg := &config.AppConfig{Env: config.Dev}
+	cfg.Webhooks.AllowPrivateNetworks = true
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	srv := &testServer{url: server.URL}
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(services.EventHeader)
+	}))
+	defer receiver.Close()
+
+	// register
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// subscribe to the money arriving
+	resp := srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/webhooks", user.Account), token, &proto.CreateWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{proto.EventCredited},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	result := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	hookID := result["data"].(map[string]interface{})["id"].(string)
+
+	// deposit
+	resp = srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/deposits", user.Account), token, &proto.DepositRequest{Amount: 10})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	select {
+	case event := <-received:
+		if event != proto.EventCredited {
+			t.Fatalf("expected event %s, got %s", proto.EventCredited, event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a delivery")
+	}
+
+	// the delivery log
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp = srv.authorizedDo(t, http.MethodGet, fmt.Sprintf("/v1/accounts/%s/webhooks/%s/deliveries", user.Account, hookID), token, nil)
+		result = make(map[string]interface{})
+		err := json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries := result["data"].([]interface{})
+		if len(deliveries) == 1 && deliveries[0].(map[string]interface{})["state"].(string) == proto.DeliveryDelivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a delivered delivery, got %v", deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := newServer(t)
+	pwd := uuid.NewString()
+	user, err := srv.register(pwd, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := srv.getToken(user.Account, pwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := srv.authorizedDo(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/deposits", user.Account), token, &proto.DepositRequest{Amount: 10})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err = client.Get(srv.url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{
+		`bank_http_requests_total{method="POST",route="/v1/accounts/:id/deposits",status="201"}`,
+		`bank_http_request_duration_seconds_bucket{method="POST",route="/v1/accounts/:id/deposits",status="201"`,
+		`bank_transactions_total{action="deposit",error="",outcome="success"}`,
+		`bank_transaction_amount_bucket{action="deposit"`,
+		`bank_lock_wait_seconds_bucket{operation="deposit"`,
+		`bank_accounts `,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Fatalf("expected metric %s", metric)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv := newServer(t)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := client.Get(srv.url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d of %s, got %d", http.StatusOK, path, resp.StatusCode)
+		}
+	}
+}
+
+// drainingBank records the shutdown steps of the bank
+type drainingBank struct {
+	services.BankInterface
+	steps chan string
+}
+
+func (b *drainingBank) Drain(ctx context.Context) {
+	b.steps <- "drain"
+}
+
+func (b *drainingBank) Close(ctx context.Context) error {
+	b.steps <- "close"
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	b := &drainingBank{steps: make(chan string, 2)}
+	done := make(chan error, 1)
+	go func() {
+		done <- shutdown(context.Background(), server.Config, nil, b)
+	}()
+	if step := <-b.steps; step != "drain" {
+		t.Fatalf("expected step %s, got %s", "drain", step)
+	}
+
+	// the request in flight finishes before the bank is closed
+	select {
+	case step := <-b.steps:
+		t.Fatalf("expected the shutdown to wait for the request, got step %s", step)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-status; code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, code)
+	}
+	if step := <-b.steps; step != "close" {
+		t.Fatalf("expected step %s, got %s", "close", step)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := http.Get(server.URL); err == nil {
+		t.Fatalf("expected the server to refuse new requests")
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg := &config.AppConfig{Env: config.Dev, HttpPort: 8080, Admin: config.AdminConfig{Token: "old"}}
+	engine, reloader := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	unlock := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/accounts/"+uuid.NewString()+"/unlock", nil)
+		req.Header.Set("X-Admin-Token", token)
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	path := t.TempDir() + "/application.yaml"
+	if err := os.WriteFile(path, []byte("env: dev\nhttp_port: 9090\nadmin:\n  token: new\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	running := reload(path, cfg, reloader)
+	if running.Admin.Token != "new" {
+		t.Fatalf("expected admin token %s, got %s", "new", running.Admin.Token)
+	}
+	// the port needs a restart
+	if running.HttpPort != cfg.HttpPort {
+		t.Fatalf("expected http port %d, got %d", cfg.HttpPort, running.HttpPort)
+	}
+	if code := unlock("old"); code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := unlock("new"); code == http.StatusUnauthorized {
+		t.Fatalf("expected the new admin token to be accepted, got %d", code)
+	}
+
+	// an invalid file keeps the running config
+	if err := os.WriteFile(path, []byte("env: dev\nadmin:\n  tokn: other\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if next := reload(path, running, reloader); next != running {
+		t.Fatalf("expected the running config to be kept")
+	}
+	if code := unlock("new"); code == http.StatusUnauthorized {
+		t.Fatalf("expected the new admin token to be accepted, got %d", code)
+	}
+}
+
+func TestAdminClientCertificate(t *testing.T) {
+	cfg := &config.AppConfig{Env: config.Dev, Admin: config.AdminConfig{Token: "token"}}
+	cfg.Server.TLS.ClientCAFile = "ca.pem"
+	engine, _ := initEngine(cfg, newBank(t, cfg, nil, nil), nil, nil)
+	unlock := func(state *tls.ConnectionState) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/accounts/"+uuid.NewString()+"/unlock", nil)
+		req.Header.Set("X-Admin-Token", "token")
+		req.TLS = state
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := unlock(nil); code != http.StatusForbidden {
+		t.Fatalf("expected status code %d, got %d", http.StatusForbidden, code)
+	}
+	if code := unlock(&tls.ConnectionState{}); code != http.StatusForbidden {
+		t.Fatalf("expected status code %d, got %d", http.StatusForbidden, code)
+	}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	if code := unlock(verified); code == http.StatusForbidden || code == http.StatusUnauthorized {
+		t.Fatalf("expected the verified client to pass, got %d", code)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv := newServer(t)
+	resp, err := client.Get(fmt.Sprintf("%s/openapi.json", srv.url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	for _, path := range []string{"/account/register", "/account/nonce", "/bank/balance", "/bank/transfer", "/bank/transactions"} {
+		if doc.Paths.Find(path) == nil {
+			t.Fatalf("expected path %s in the document", path)
+		}
+	}
+}
+
+// specTransport fails every round trip whose request or response does not
+// match the OpenAPI document, so the tests above double as contract tests
+type specTransport struct {
+	router routers.Router
+}
+
+func newSpecTransport(spec []byte) (*specTransport, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	r, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &specTransport{router: r}, nil
+}
+
+func (s *specTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	route, params, err := s.router.FindRoute(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s is not in the document: %w", req.Method, req.URL.Path, err)
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// an event stream is read by the test, only its headers are checked
+	stream := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+	if stream {
+		err := openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: params, Route: route},
+			Status:                 resp.StatusCode,
+			Header:                 resp.Header,
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true, ExcludeResponseBody: true},
+		})
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("response of %s %s: %w", req.Method, req.URL.Path, err)
+		}
+		return resp, nil
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// the request body was consumed by the round trip
+	validateReq := req.Clone(req.Context())
+	validateReq.Body = io.NopCloser(bytes.NewReader(body))
+	input := &openapi3filter.RequestValidationInput{
+		Request:    validateReq,
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	// invalid requests are sent on purpose, only the success responses
+	// need a valid request
+	if resp.StatusCode < http.StatusBadRequest {
+		if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			return nil, fmt.Errorf("request of %s %s: %w", req.Method, req.URL.Path, err)
+		}
+	}
+	err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(bytes.NewReader(respBody)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("response of %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return resp, nil
+}
+
+func (srv *testServer) authorizedStatus(t *testing.T, method, path, token string) int {
+	resp := srv.authorizedDo(t, method, path, token, nil)
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (srv *testServer) authorizedDo(t *testing.T, method, path, token string, body interface{}) *http.Response {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", srv.url, path), reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func (srv *testServer) register(pwd string, balance int) (*proto.User, error) {
 
 	req := &proto.CreateAccountRequest{
 		Password: pwd,
@@ -489,7 +1677,7 @@ func register(pwd string, balance int) (*proto.User, error) {
 		return nil, err
 	}
 
-	resp, err := http.Post(fmt.Sprintf("%s/account/register", baseURL), contentType, bytes.NewReader(b))
+	resp, err := client.Post(fmt.Sprintf("%s/account/register", srv.url), contentType, bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
@@ -513,7 +1701,7 @@ func register(pwd string, balance int) (*proto.User, error) {
 	return user, nil
 }
 
-func getToken(account, pwd string) (string, error) {
+func (srv *testServer) getToken(account, pwd string) (string, error) {
 	reqGetNonce := &proto.GetTokenRequest{
 		Account:  account,
 		Password: pwd,
@@ -522,7 +1710,7 @@ func getToken(account, pwd string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	resp, err := http.Post(fmt.Sprintf("%s/account/nonce", baseURL), contentType, bytes.NewReader(b))
+	resp, err := client.Post(fmt.Sprintf("%s/account/nonce", srv.url), contentType, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,67 @@
+// Command auditverify checks the hash chain of an audit log, it exits with
+// 1 when an entry was modified, removed or inserted and with 2 when the log
+// can not be read.
+//
+//	auditverify -file audit.log [-seq 42 -head <hash>]
+//
+// The chain alone can not show that the latest entries were cut off, pass
+// the sequence number and the hash of a known last entry to check that too.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/0x726f6f6b6965/bank/internal/audit"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("auditverify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("file", "", "the audit log to verify")
+	seq := flags.Uint64("seq", 0, "the sequence number of the expected last entry")
+	head := flags.String("head", "", "the hash of the expected last entry")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(stderr, "auditverify: -file is required")
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "auditverify: %v\n", err)
+		return 2
+	}
+	defer f.Close()
+
+	var (
+		last audit.Entry
+		n    int
+	)
+	if *head != "" {
+		last, n, err = audit.VerifyHead(f, *seq, *head)
+	} else {
+		last, n, err = audit.Verify(f)
+	}
+	switch {
+	case errors.Is(err, audit.ErrBrokenChain), errors.Is(err, audit.ErrHead):
+		fmt.Fprintf(stderr, "auditverify: %s: %v\n", *path, err)
+		fmt.Fprintf(stderr, "auditverify: %d entries are intact\n", n)
+		return 1
+	case err != nil:
+		fmt.Fprintf(stderr, "auditverify: %s: %v\n", *path, err)
+		return 2
+	}
+	fmt.Fprintf(stdout, "ok: %d entries, head %d %s\n", n, last.Seq, last.Hash)
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/audit"
+)
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	chain, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, account := range []string{"a", "b", "c"} {
+		chain.Append(map[string]string{"type": "account.created", "account": account})
+	}
+	seq, head := chain.Head()
+	chain.Close()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"-file", path}, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code %d, got %d: %s", 0, code, stderr)
+	}
+	if !strings.Contains(stdout.String(), "3 entries") {
+		t.Fatalf("expected the number of entries, got %q", stdout)
+	}
+	if code := run([]string{"-file", path, "-seq", fmt.Sprint(seq), "-head", head}, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code %d, got %d: %s", 0, code, stderr)
+	}
+	if code := run([]string{"-file", path, "-seq", fmt.Sprint(seq + 1), "-head", head}, stdout, stderr); code != 1 {
+		t.Fatalf("expected exit code %d, got %d", 1, code)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, bytes.Replace(data, []byte(`"account":"b"`), []byte(`"account":"x"`), 1), 0o600)
+	stderr.Reset()
+	if code := run([]string{"-file", path}, stdout, stderr); code != 1 {
+		t.Fatalf("expected exit code %d, got %d", 1, code)
+	}
+	if !strings.Contains(stderr.String(), "line 2") {
+		t.Fatalf("expected the broken line, got %q", stderr)
+	}
+
+	if code := run([]string{"-file", filepath.Join(t.TempDir(), "missing.log")}, stdout, stderr); code != 2 {
+		t.Fatalf("expected exit code %d, got %d", 2, code)
+	}
+}
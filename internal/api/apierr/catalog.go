@@ -0,0 +1,143 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrInvalidAction    = errors.New("invalid action")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrNotFound         = errors.New("resource not found")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrInternal         = errors.New("service internal exception")
+	ErrRateLimited      = errors.New("too many requests")
+)
+
+// Error - how an error is presented to the clients
+type Error struct {
+	// Status is the HTTP status of the response.
+	Status int `json:"status"`
+	// Code is the stable machine readable code of the error.
+	Code string `json:"code"`
+}
+
+var (
+	validationError = Error{http.StatusBadRequest, "VALIDATION_FAILED"}
+	internalError   = Error{http.StatusInternalServerError, "INTERNAL_ERROR"}
+)
+
+// catalog is matched in order with errors.Is
+var catalog = []struct {
+	err error
+	Error
+}{
+	{ErrInvalidRequest, Error{http.StatusBadRequest, "INVALID_REQUEST"}},
+	{ErrInvalidAction, Error{http.StatusBadRequest, "INVALID_ACTION"}},
+	{ErrUnauthorized, Error{http.StatusUnauthorized, "UNAUTHORIZED"}},
+	{ErrForbidden, Error{http.StatusForbidden, "FORBIDDEN"}},
+	{ErrNotFound, Error{http.StatusNotFound, "NOT_FOUND"}},
+	{ErrMethodNotAllowed, Error{http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"}},
+	{ErrInternal, internalError},
+	{ErrRateLimited, Error{http.StatusTooManyRequests, "RATE_LIMITED"}},
+
+	{services.ErrEmptyAccount, Error{http.StatusBadRequest, "EMPTY_ACCOUNT"}},
+	{services.ErrAccountExist, Error{http.StatusConflict, "ACCOUNT_EXISTS"}},
+	{services.ErrAccountNotExist, Error{http.StatusNotFound, "ACCOUNT_NOT_FOUND"}},
+	{services.ErrEmptyPwd, Error{http.StatusBadRequest, "EMPTY_PASSWORD"}},
+	{services.ErrEmptyNonce, Error{http.StatusBadRequest, "EMPTY_NONCE"}},
+	{services.ErrVerify, Error{http.StatusUnauthorized, "VERIFY_FAILED"}},
+	{services.ErrNegativeBalance, Error{http.StatusBadRequest, "NEGATIVE_AMOUNT"}},
+	{services.ErrBalanceNotEnough, Error{http.StatusUnprocessableEntity, "INSUFFICIENT_BALANCE"}},
+	{services.ErrFromAccount, Error{http.StatusBadRequest, "INVALID_FROM_ACCOUNT"}},
+	{services.ErrToAccount, Error{http.StatusBadRequest, "INVALID_TO_ACCOUNT"}},
+	{services.ErrSameAccount, Error{http.StatusBadRequest, "SAME_ACCOUNT"}},
+	{services.ErrAccountLocked, Error{http.StatusLocked, "ACCOUNT_LOCKED"}},
+	{services.ErrTooManyAttempts, Error{http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS"}},
+	{services.ErrTOTPRequired, Error{http.StatusUnauthorized, "TOTP_REQUIRED"}},
+	{services.ErrTOTPInvalid, Error{http.StatusUnauthorized, "TOTP_INVALID"}},
+	{services.ErrTOTPEnrolled, Error{http.StatusConflict, "TOTP_ALREADY_ENROLLED"}},
+	{services.ErrTOTPNotEnrolled, Error{http.StatusNotFound, "TOTP_NOT_ENROLLED"}},
+	{services.ErrResetToken, Error{http.StatusBadRequest, "INVALID_RESET_TOKEN"}},
+	{services.ErrInvalidToken, Error{http.StatusUnauthorized, "INVALID_TOKEN"}},
+	{services.ErrTokenRevoked, Error{http.StatusUnauthorized, "TOKEN_REVOKED"}},
+	{services.ErrSessionNotFound, Error{http.StatusNotFound, "SESSION_NOT_FOUND"}},
+	{services.ErrTxNotFound, Error{http.StatusNotFound, "TRANSACTION_NOT_FOUND"}},
+	{services.ErrEventsDisabled, Error{http.StatusServiceUnavailable, "EVENTS_DISABLED"}},
+	{services.ErrWebhooksDisabled, Error{http.StatusServiceUnavailable, "WEBHOOKS_DISABLED"}},
+	{services.ErrWebhookURL, Error{http.StatusBadRequest, "INVALID_WEBHOOK_URL"}},
+	{services.ErrWebhookTarget, Error{http.StatusBadRequest, "FORBIDDEN_WEBHOOK_TARGET"}},
+	{services.ErrWebhookEvents, Error{http.StatusBadRequest, "INVALID_WEBHOOK_EVENTS"}},
+	{services.ErrTooManyWebhooks, Error{http.StatusConflict, "TOO_MANY_WEBHOOKS"}},
+	{services.ErrWebhookNotFound, Error{http.StatusNotFound, "WEBHOOK_NOT_FOUND"}},
+	{services.ErrDeliveryNotFound, Error{http.StatusNotFound, "DEAD_LETTER_NOT_FOUND"}},
+	{services.ErrShuttingDown, Error{http.StatusServiceUnavailable, "SHUTTING_DOWN"}},
+	{services.ErrStorageDown, Error{http.StatusServiceUnavailable, "STORAGE_UNAVAILABLE"}},
+	{utils.ErrTokenExpire, Error{http.StatusUnauthorized, "TOKEN_EXPIRED"}},
+}
+
+// Lookup returns the catalog entry of err, unknown errors are internal errors
+func Lookup(err error) Error {
+	var verr *policy.ValidationError
+	if errors.As(err, &verr) {
+		return validationError
+	}
+	for _, c := range catalog {
+		if errors.Is(err, c.err) {
+			return c.Error
+		}
+	}
+	return internalError
+}
+
+// InvalidRequest wraps an error of the request binding, a field of the
+// wrong type becomes a validation error of the field
+func InvalidRequest(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		verr := &policy.ValidationError{}
+		verr.Add(typeErr.Field, fmt.Errorf("%w: must be %s", ErrInvalidRequest, typeErr.Type.String()))
+		return verr
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+}
+
+// Abort stops the request with the envelope or the problem document of
+// err, the message of an internal error is logged instead of exposed
+func Abort(ctx *gin.Context, err error) {
+	e := Lookup(err)
+	msg := err.Error()
+	if e.Status == http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "internal error", "error", err)
+		msg = ErrInternal.Error()
+	}
+
+	var fields []policy.FieldError
+	var verr *policy.ValidationError
+	if errors.As(err, &verr) {
+		fields = verr.Fields
+	}
+
+	if opts := options(ctx); opts.Problem {
+		// the JSON render keeps a content type which is already set
+		ctx.Header("Content-Type", ProblemContentType)
+		ctx.AbortWithStatusJSON(e.Status, problem(ctx, opts, e, msg, fields))
+		return
+	}
+
+	var data interface{}
+	if fields != nil {
+		data = fields
+	}
+	utils.ErrorResponse(ctx, e.Status, e.Code, msg, data)
+}
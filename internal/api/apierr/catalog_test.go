@@ -0,0 +1,86 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCatalogCodesAreUnique(t *testing.T) {
+	seen := map[string]error{}
+	for _, c := range catalog {
+		if other, ok := seen[c.Code]; ok && c.Error != internalError {
+			t.Fatalf("Code %v is used by %v and %v", c.Code, other, c.err)
+		}
+		seen[c.Code] = c.err
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{services.ErrBalanceNotEnough, http.StatusUnprocessableEntity, "INSUFFICIENT_BALANCE"},
+		{fmt.Errorf("wrapped: %w", services.ErrAccountNotExist), http.StatusNotFound, "ACCOUNT_NOT_FOUND"},
+		{InvalidRequest(errors.New("EOF")), http.StatusBadRequest, "INVALID_REQUEST"},
+		{errors.New("unknown"), http.StatusInternalServerError, "INTERNAL_ERROR"},
+	}
+	for _, c := range cases {
+		e := Lookup(c.err)
+		if e.Status != c.status || e.Code != c.code {
+			t.Fatalf("Expected %v: %d %v, got: %d %v", c.err, c.status, c.code, e.Status, e.Code)
+		}
+	}
+
+	// a validation error wins over the sentinels it wraps
+	verr := &policy.ValidationError{}
+	verr.Add("password", services.ErrEmptyPwd)
+	if e := Lookup(verr); e != validationError {
+		t.Fatalf("Expected: %v, got: %v", validationError, e)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	verr := &policy.ValidationError{}
+	verr.Add("password", policy.ErrPasswordTooShort)
+
+	cases := []struct {
+		err     error
+		status  int
+		message string
+		fields  int
+	}{
+		{services.ErrTokenRevoked, http.StatusUnauthorized, services.ErrTokenRevoked.Error(), 0},
+		{errors.New("disk on fire"), http.StatusInternalServerError, ErrInternal.Error(), 0},
+		{verr, http.StatusBadRequest, verr.Error(), 1},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		Abort(ctx, c.err)
+
+		if w.Code != c.status {
+			t.Fatalf("Expected status: %d, got: %d", c.status, w.Code)
+		}
+		result := make(map[string]interface{})
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if int(result["code"].(float64)) != c.status || result["error"] != Lookup(c.err).Code || result["message"] != c.message {
+			t.Fatalf("Unexpected envelope: %v", result)
+		}
+		if c.fields > 0 && len(result["data"].([]interface{})) != c.fields {
+			t.Fatalf("Expected fields: %d, got: %v", c.fields, result["data"])
+		}
+	}
+}
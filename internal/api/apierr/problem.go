@@ -0,0 +1,101 @@
+package apierr
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ProblemContentType     = "application/problem+json"
+	FormatProblem          = "problem"
+	DefaultProblemTypeBase = "urn:simple-bank:problem:"
+
+	optionsKey = "apierr_options"
+)
+
+// Options - how the errors of a request are rendered
+type Options struct {
+	Problem  bool
+	TypeBase string
+}
+
+// Problem - RFC 7807 problem details document
+type Problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail"`
+	Instance string              `json:"instance"`
+	Code     string              `json:"code"`
+	Errors   []policy.FieldError `json:"errors,omitempty"`
+}
+
+// NewOptions returns the options of the config
+func NewOptions(cfg config.ErrorsConfig) Options {
+	base := cfg.ProblemTypeBase
+	if base == "" {
+		base = DefaultProblemTypeBase
+	}
+	return Options{
+		Problem:  cfg.Format == FormatProblem,
+		TypeBase: base,
+	}
+}
+
+// SetOptions stores the options in the request context
+func SetOptions(ctx *gin.Context, opts Options) {
+	ctx.Set(optionsKey, opts)
+}
+
+func options(ctx *gin.Context) Options {
+	v, _ := ctx.Get(optionsKey)
+	opts, ok := v.(Options)
+	if !ok {
+		opts = NewOptions(config.ErrorsConfig{})
+	}
+	if ctx.Request != nil && acceptsProblem(ctx.GetHeader("Accept")) {
+		opts.Problem = true
+	}
+	return opts
+}
+
+// acceptsProblem reports whether the Accept header asks for a problem document
+func acceptsProblem(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == ProblemContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// problem builds the problem document of the error
+func problem(ctx *gin.Context, opts Options, e Error, detail string, fields []policy.FieldError) Problem {
+	var instance string
+	if ctx.Request != nil {
+		instance = ctx.Request.URL.Path
+	}
+	return Problem{
+		Type:     opts.TypeBase + strings.ToLower(strings.ReplaceAll(e.Code, "_", "-")),
+		Title:    e.Title(),
+		Status:   e.Status,
+		Detail:   detail,
+		Instance: instance,
+		Code:     e.Code,
+		Errors:   fields,
+	}
+}
+
+// Title returns the human readable summary of the error code
+func (e Error) Title() string {
+	title := strings.ToLower(strings.ReplaceAll(e.Code, "_", " "))
+	if title == "" {
+		return title
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
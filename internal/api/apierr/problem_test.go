@@ -0,0 +1,91 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsProblem(t *testing.T) {
+	cases := map[string]bool{
+		"":                         false,
+		"application/json":         false,
+		"application/problem+json": true,
+		"application/json, application/problem+json;q=0.9": true,
+		"text/html, */*": false,
+	}
+	for accept, expected := range cases {
+		if acceptsProblem(accept) != expected {
+			t.Fatalf("Expected %q: %v", accept, expected)
+		}
+	}
+}
+
+func TestAbortProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		format string
+		accept string
+	}{
+		{"problem", ""},
+		{"", ProblemContentType},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/bank/transfer", nil)
+		ctx.Request.Header.Set("Accept", c.accept)
+		SetOptions(ctx, NewOptions(config.ErrorsConfig{Format: c.format}))
+
+		Abort(ctx, services.ErrBalanceNotEnough)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected status: %d, got: %d", http.StatusUnprocessableEntity, w.Code)
+		}
+		if w.Header().Get("Content-Type") != ProblemContentType {
+			t.Fatalf("Expected content type: %v, got: %v", ProblemContentType, w.Header().Get("Content-Type"))
+		}
+		var p Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := Problem{
+			Type:     DefaultProblemTypeBase + "insufficient-balance",
+			Title:    "Insufficient balance",
+			Status:   http.StatusUnprocessableEntity,
+			Detail:   services.ErrBalanceNotEnough.Error(),
+			Instance: "/bank/transfer",
+			Code:     "INSUFFICIENT_BALANCE",
+		}
+		if p.Type != expected.Type || p.Title != expected.Title || p.Status != expected.Status ||
+			p.Detail != expected.Detail || p.Instance != expected.Instance || p.Code != expected.Code {
+			t.Fatalf("Expected problem: %+v, got: %+v", expected, p)
+		}
+	}
+}
+
+func TestInvalidRequestFields(t *testing.T) {
+	var body struct {
+		Amount int `json:"amount"`
+	}
+	err := json.Unmarshal([]byte(`{"amount": "ten"}`), &body)
+	e := InvalidRequest(err)
+
+	if Lookup(e) != validationError {
+		t.Fatalf("Expected: %v, got: %v", validationError, Lookup(e))
+	}
+	if !errors.Is(e, ErrInvalidRequest) {
+		t.Fatalf("Expected error: %v, got: %v", ErrInvalidRequest, e)
+	}
+
+	e = InvalidRequest(errors.New("EOF"))
+	if Lookup(e).Code != "INVALID_REQUEST" {
+		t.Fatalf("Expected code: INVALID_REQUEST, got: %v", Lookup(e).Code)
+	}
+}
@@ -1,74 +1,178 @@
 package api
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
 	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
 	"github.com/0x726f6f6b6965/bank/internal/proto"
 	"github.com/0x726f6f6b6965/bank/internal/utils"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
-var BankAPI *bankApi
+var (
+	// TokenTTL - how long an issued access token is valid when jwt.token_ttl is unset
+	TokenTTL = 5 * time.Minute
+	// DefaultEventHeartbeat - the interval of the keep-alive comments of an event stream
+	DefaultEventHeartbeat = 15 * time.Second
+)
 
-type bankApi struct{}
+// BankAPI - the http handlers of a bank
+type BankAPI struct {
+	bank     services.BankInterface
+	tokenTTL time.Duration
+	clock    clock.Clock
+	ids      ids.Generator
+}
 
-func (api *bankApi) GetBalance(ctx *gin.Context) {
-	b := services.GetBankService()
-	if b == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, "service not found", nil)
-		return
+// NewBankAPI returns the handlers serving b, the tokens are issued at the
+// time of clk and the tokens and the accounts are named by gen, nil stands
+// for the system clock and random UUIDs
+func NewBankAPI(b services.BankInterface, cfg *config.AppConfig, clk clock.Clock, gen ids.Generator) *BankAPI {
+	tokenTTL := cfg.JWT.TokenTTL
+	if tokenTTL <= 0 {
+		tokenTTL = TokenTTL
 	}
-	var param *proto.UserToken
-	if token, ok := ctx.Get("access_token"); !ok || token.(*proto.UserToken) == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, "invalid token", nil)
+	return &BankAPI{bank: b, tokenTTL: tokenTTL, clock: clock.Or(clk), ids: ids.Or(gen)}
+}
+
+// Bank returns the bank the handlers serve
+func (api *BankAPI) Bank() services.BankInterface {
+	return api.bank
+}
+
+// Clock returns the clock the tokens are checked with
+func (api *BankAPI) Clock() clock.Clock {
+	return api.clock
+}
+
+func (api *BankAPI) GetBalance(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
 		return
-	} else {
-		param = token.(*proto.UserToken)
 	}
 
-	balance, err := b.GetBalance(ctx, param.Account)
+	balance, err := b.GetBalance(ctx, token.Account)
 	if err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, err.Error(), nil)
+		apierr.Abort(ctx, err)
 		return
 	}
 
 	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", balance)
 }
 
-func (api *bankApi) Transfer(ctx *gin.Context) {
-	b := services.GetBankService()
-	if b == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, "service not found", nil)
+func (api *BankAPI) Transfer(ctx *gin.Context) {
+	var param proto.TransactionRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	result, err := api.transact(ctx, param)
+	if err != nil {
+		apierr.Abort(ctx, err)
 		return
 	}
 
-	var token *proto.UserToken
-	if t, ok := ctx.Get("access_token"); !ok || t.(*proto.UserToken) == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, "invalid token", nil)
+	resp := proto.TransactionResponse{
+		ID: result.ID,
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
+
+func (api *BankAPI) Deposit(ctx *gin.Context) {
+	var param proto.DepositRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
 		return
-	} else {
-		token = t.(*proto.UserToken)
 	}
+	api.createTransaction(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionDeposit,
+		To:     ctx.Param("id"),
+		Amount: param.Amount,
+	})
+}
 
-	var param proto.TransactionRequest
+func (api *BankAPI) Withdraw(ctx *gin.Context) {
+	var param proto.WithdrawalRequest
 	if err := ctx.ShouldBindJSON(&param); err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, err.Error(), nil)
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
 		return
 	}
+	api.createTransaction(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionWithdraw,
+		From:   ctx.Param("id"),
+		Amount: param.Amount,
+		TOTP:   param.TOTP,
+	})
+}
+
+func (api *BankAPI) CreateTransfer(ctx *gin.Context) {
+	var param proto.TransferRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	api.createTransaction(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionTransfer,
+		From:   ctx.Param("id"),
+		To:     param.To,
+		Amount: param.Amount,
+		TOTP:   param.TOTP,
+	})
+}
+
+// createTransaction runs the request of a /v1 route and points to the
+// created transaction
+func (api *BankAPI) createTransaction(ctx *gin.Context, param proto.TransactionRequest) {
+	result, err := api.transact(ctx, param)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+
+	ctx.Header("Location", fmt.Sprintf("/v1/transactions/%d", result.ID))
+	resp := proto.TransactionResponse{
+		ID: result.ID,
+	}
+	utils.Response(ctx, http.StatusCreated, http.StatusCreated, "success", resp)
+}
+
+// transact validates the request and runs its action with the token of the caller
+func (api *BankAPI) transact(ctx *gin.Context, param proto.TransactionRequest) (*proto.Transaction, error) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		return nil, services.ErrInvalidToken
+	}
+	if err := ValidateTransaction(param); err != nil {
+		return nil, err
+	}
 	tx := proto.Transaction{
 		From:   param.From,
 		To:     param.To,
 		Amount: param.Amount,
 	}
 
+	if param.Action == proto.TransactionActionWithdraw || param.Action == proto.TransactionActionTransfer {
+		if err := b.StepUp(ctx, token.Account, param.Amount, param.TOTP); err != nil {
+			return nil, err
+		}
+	}
+
 	var (
 		result *proto.Transaction
 		err    error
 	)
-
 	switch param.Action {
 	case proto.TransactionActionDeposit:
 		result, _, err = b.Deposit(ctx, tx, token.Nonce)
@@ -77,84 +181,377 @@ func (api *bankApi) Transfer(ctx *gin.Context) {
 	case proto.TransactionActionTransfer:
 		result, _, err = b.Transaction(ctx, tx, token.Nonce)
 	default:
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, "invalid action", nil)
+		return nil, apierr.ErrInvalidAction
+	}
+	return result, err
+}
+
+func (api *BankAPI) GetToken(ctx *gin.Context) {
+	b := api.bank
+	var param proto.GetTokenRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
 		return
 	}
+	nonce, err := b.GetNonce(services.WithClientIP(ctx, ctx.ClientIP()), param.Account, param.Password, param.TOTP)
 	if err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, err.Error(), nil)
+		apierr.Abort(ctx, err)
 		return
 	}
+	token, metadata, err := utils.GenerateNewAccessToken(api.ids.NewID(), param.Account, nonce, api.clock.Now(), api.tokenTTL)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	sessionCtx := services.WithUserAgent(services.WithClientIP(ctx, ctx.ClientIP()), ctx.Request.UserAgent())
+	if err := b.TrackToken(sessionCtx, metadata); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", token)
+}
 
-	resp := proto.TransactionResponse{
-		ID: result.ID,
+func (api *BankAPI) CreateAccount(ctx *gin.Context) {
+	b := api.bank
+	var param proto.CreateAccountRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	user := proto.User{
+		Account:  api.ids.NewID(),
+		Password: param.Password,
+		Name:     param.Name,
+		Balance:  param.Balance,
 	}
+	resp, err := b.CreateAccount(ctx, user)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	resp.Password = ""
 	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
 }
 
-func (api *bankApi) GetToken(ctx *gin.Context) {
-	b := services.GetBankService()
-	if b == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, "service not found", nil)
+func (api *BankAPI) GetTransactions(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
 		return
 	}
-	var param proto.GetTokenRequest
+	resp, err := b.GetTransactions(ctx, token.Account)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
+
+func (api *BankAPI) GetTransaction(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		apierr.Abort(ctx, services.ErrTxNotFound)
+		return
+	}
+	resp, err := b.GetTransaction(ctx, token.Account, id)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
+
+// Events streams the balance changes and the transactions of the caller as
+// server-sent events, a client resumes after the event ID of the
+// Last-Event-ID header or the last_event_id query, the stream ends when
+// the token expires
+func (api *BankAPI) Events(heartbeat time.Duration) gin.HandlerFunc {
+	if heartbeat <= 0 {
+		heartbeat = DefaultEventHeartbeat
+	}
+	return func(ctx *gin.Context) {
+		b := api.bank
+		token, ok := accessToken(ctx)
+		if !ok {
+			apierr.Abort(ctx, services.ErrInvalidToken)
+			return
+		}
+		var after uint64
+		lastID := ctx.GetHeader("Last-Event-ID")
+		if lastID == "" {
+			lastID = ctx.Query("last_event_id")
+		}
+		if lastID != "" {
+			id, err := strconv.ParseUint(lastID, 10, 64)
+			if err != nil {
+				apierr.Abort(ctx, apierr.InvalidRequest(err))
+				return
+			}
+			after = id
+		}
+		events, err := b.Subscribe(ctx.Request.Context(), token.Account, after)
+		if err != nil {
+			apierr.Abort(ctx, err)
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		expire := time.NewTimer(time.Unix(token.ExpireAt, 0).Sub(api.clock.Now()))
+		defer expire.Stop()
+
+		// send the headers before the first event
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no")
+		ctx.Status(http.StatusOK)
+		ctx.Writer.Flush()
+		ctx.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				ctx.Render(-1, sse.Event{
+					Id:    strconv.FormatUint(event.ID, 10),
+					Event: event.Type,
+					Data:  event,
+				})
+				return true
+			case <-ticker.C:
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err == nil
+			case <-expire.C:
+				return false
+			case <-ctx.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+func (api *BankAPI) CreateWebhook(ctx *gin.Context) {
+	b := api.bank
+	var param proto.CreateWebhookRequest
 	if err := ctx.ShouldBindJSON(&param); err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, err.Error(), nil)
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
 		return
 	}
-	nonce, err := b.GetNonce(ctx, param.Account, param.Password)
+	resp, err := b.CreateWebhook(ctx, ctx.Param("id"), param)
 	if err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, err.Error(), nil)
+		apierr.Abort(ctx, err)
 		return
 	}
-	token, _ := utils.GenerateNewAccessToken(param.Account, nonce, 5*time.Minute)
-	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", token)
+	utils.Response(ctx, http.StatusCreated, http.StatusCreated, "success", resp)
 }
 
-func (api *bankApi) CreateAccount(ctx *gin.Context) {
-	b := services.GetBankService()
-	if b == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, "service not found", nil)
+func (api *BankAPI) GetWebhooks(ctx *gin.Context) {
+	b := api.bank
+	resp, err := b.GetWebhooks(ctx, ctx.Param("id"))
+	if err != nil {
+		apierr.Abort(ctx, err)
 		return
 	}
-	var param proto.CreateAccountRequest
-	if err := ctx.ShouldBindJSON(&param); err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, err.Error(), nil)
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
+
+func (api *BankAPI) DeleteWebhook(ctx *gin.Context) {
+	b := api.bank
+	if err := b.DeleteWebhook(ctx, ctx.Param("id"), ctx.Param("webhook")); err != nil {
+		apierr.Abort(ctx, err)
 		return
 	}
-	user := proto.User{
-		Account:  uuid.NewString(),
-		Password: param.Password,
-		Name:     param.Name,
-		Balance:  param.Balance,
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) GetDeliveries(ctx *gin.Context) {
+	b := api.bank
+	resp, err := b.GetDeliveries(ctx, ctx.Param("id"), ctx.Param("webhook"))
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
 	}
-	resp, err := b.CreateAccount(ctx, user)
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
+
+func (api *BankAPI) GetDeadLetters(ctx *gin.Context) {
+	b := api.bank
+	resp, err := b.GetDeadLetters(ctx, ctx.Param("id"), ctx.Param("webhook"))
 	if err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, err.Error(), nil)
+		apierr.Abort(ctx, err)
 		return
 	}
-	resp.Password = ""
 	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
 }
 
-func (api *bankApi) GetTransactions(ctx *gin.Context) {
-	b := services.GetBankService()
-	if b == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, "service not found", nil)
+func (api *BankAPI) RetryDeadLetter(ctx *gin.Context) {
+	b := api.bank
+	if err := b.RetryDeadLetter(ctx, ctx.Param("id"), ctx.Param("webhook"), ctx.Param("delivery")); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusAccepted, http.StatusAccepted, "success", nil)
+}
+
+func (api *BankAPI) UnlockAccount(ctx *gin.Context) {
+	b := api.bank
+	if err := b.UnlockAccount(ctx, ctx.Param("account")); err != nil {
+		apierr.Abort(ctx, err)
 		return
 	}
-	var param *proto.UserToken
-	if token, ok := ctx.Get("access_token"); !ok || token.(*proto.UserToken) == nil {
-		utils.Response(ctx, http.StatusOK, http.StatusBadRequest, "invalid token", nil)
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) EnrollTOTP(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
 		return
-	} else {
-		param = token.(*proto.UserToken)
 	}
-	resp, err := b.GetTransactions(ctx, param.Account)
+	resp, err := b.EnrollTOTP(ctx, token.Account)
 	if err != nil {
-		utils.Response(ctx, http.StatusOK, http.StatusInternalServerError, err.Error(), nil)
+		apierr.Abort(ctx, err)
 		return
 	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
+}
 
+func (api *BankAPI) ConfirmTOTP(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	var param proto.VerifyTOTPRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	if err := b.ConfirmTOTP(ctx, token.Account, param.Code); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) ChangePassword(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	var param proto.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	err := b.ChangePassword(services.WithClientIP(ctx, ctx.ClientIP()), token.Account, param.OldPassword, param.NewPassword)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) RequestPasswordReset(ctx *gin.Context) {
+	b := api.bank
+	var param proto.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	if err := b.RequestPasswordReset(services.WithClientIP(ctx, ctx.ClientIP()), param.Account); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) ResetPassword(ctx *gin.Context) {
+	b := api.bank
+	var param proto.ConfirmResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&param); err != nil {
+		apierr.Abort(ctx, apierr.InvalidRequest(err))
+		return
+	}
+	if err := b.ResetPassword(services.WithClientIP(ctx, ctx.ClientIP()), param.Token, param.NewPassword); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) Logout(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	if err := b.Logout(services.WithClientIP(ctx, ctx.ClientIP()), token); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) LogoutAll(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	if err := b.LogoutAll(services.WithClientIP(ctx, ctx.ClientIP()), token.Account); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+func (api *BankAPI) GetSessions(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	resp, err := b.GetSessions(ctx, token)
+	if err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
 	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", resp)
 }
+
+func (api *BankAPI) RevokeSession(ctx *gin.Context) {
+	b := api.bank
+	token, ok := accessToken(ctx)
+	if !ok {
+		apierr.Abort(ctx, services.ErrInvalidToken)
+		return
+	}
+	if err := b.RevokeSession(services.WithClientIP(ctx, ctx.ClientIP()), token.Account, ctx.Param("id")); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+// accessToken returns the token which UserAuthorization verified
+func accessToken(ctx *gin.Context) (*proto.UserToken, bool) {
+	t, ok := ctx.Get("access_token")
+	token, _ := t.(*proto.UserToken)
+	return token, ok && token != nil
+}
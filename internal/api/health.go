@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz reports that the process serves requests
+func (api *BankAPI) Healthz(ctx *gin.Context) {
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+// Readyz reports whether the bank takes requests, it fails during the
+// shutdown so the load balancer stops sending new ones
+func (api *BankAPI) Readyz(ctx *gin.Context) {
+	if err := api.bank.Ready(ctx); err != nil {
+		apierr.Abort(ctx, err)
+		return
+	}
+	utils.Response(ctx, http.StatusOK, http.StatusOK, "success", nil)
+}
+
+// NoRoute answers the paths no route matches
+func (api *BankAPI) NoRoute(ctx *gin.Context) {
+	apierr.Abort(ctx, apierr.ErrNotFound)
+}
+
+// NoMethod answers the methods a matched path does not serve
+func (api *BankAPI) NoMethod(ctx *gin.Context) {
+	apierr.Abort(ctx, apierr.ErrMethodNotAllowed)
+}
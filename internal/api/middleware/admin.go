@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuthorization checks the X-Admin-Token header against the current
+// token, it changes when the config is reloaded
+func AdminAuthorization(current func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("X-Admin-Token")
+		token := current()
+		if token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+			apierr.Abort(c, apierr.ErrUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ClientCertificate requires a client certificate verified against the
+// client CAs of the server
+func ClientCertificate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			apierr.Abort(c, apierr.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
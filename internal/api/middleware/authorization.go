@@ -1,20 +1,52 @@
 package middleware
 
 import (
-	"net/http"
+	"errors"
 
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
 	"github.com/0x726f6f6b6965/bank/internal/utils"
 	"github.com/gin-gonic/gin"
 )
 
-func UserAuthorization() gin.HandlerFunc {
+// UserAuthorization lets the requests with a valid token of b through, the
+// expiry of the tokens is checked at the time of clk
+func UserAuthorization(b services.BankInterface, clk clock.Clock) gin.HandlerFunc {
+	clk = clock.Or(clk)
 	return func(c *gin.Context) {
-		token, err := utils.ExtractTokenMetadata(c.Request)
+		token, err := utils.CheckToken(c.Request, clk.Now())
 		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			if !errors.Is(err, utils.ErrTokenExpire) {
+				err = services.ErrInvalidToken
+			}
+			apierr.Abort(c, err)
+			return
+		}
+		if err := b.VerifyToken(c, token); err != nil {
+			apierr.Abort(c, err)
 			return
 		}
 		c.Set("access_token", token)
 		c.Next()
 	}
 }
+
+// AccountOwner lets only the owner of the account in the path parameter
+// through, it runs after UserAuthorization
+func AccountOwner(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, _ := c.Get("access_token")
+		token, _ := t.(*proto.UserToken)
+		if token == nil {
+			apierr.Abort(c, services.ErrInvalidToken)
+			return
+		}
+		if c.Param(param) != token.Account {
+			apierr.Abort(c, apierr.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
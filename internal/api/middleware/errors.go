@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorFormat selects how the errors of every request are rendered
+func ErrorFormat(cfg config.ErrorsConfig) gin.HandlerFunc {
+	opts := apierr.NewOptions(cfg)
+	return func(c *gin.Context) {
+		apierr.SetOptions(c, opts)
+		c.Next()
+	}
+}
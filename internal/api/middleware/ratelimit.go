@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit limits the requests of the group per IP, and per account once
+// UserAuthorization ran. A rejected request gets 429 with Retry-After.
+func RateLimit(limiter *ratelimit.Limiter, group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit(c, limiter, group)
+	}
+}
+
+// RateLimitAccess is RateLimit with the read group for GET and HEAD and the
+// write group for the other methods
+func RateLimitAccess(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group := ratelimit.GroupWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			group = ratelimit.GroupRead
+		}
+		limit(c, limiter, group)
+	}
+}
+
+func limit(c *gin.Context, limiter *ratelimit.Limiter, group string) {
+	keys := []string{"ip:" + c.ClientIP()}
+	if t, ok := c.Get("access_token"); ok {
+		if token, _ := t.(*proto.UserToken); token != nil {
+			keys = append(keys, "account:"+token.Account)
+		}
+	}
+	wait, ok := limiter.Allow(c, group, keys...)
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		apierr.Abort(c, apierr.ErrRateLimited)
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,19 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Spec - the OpenAPI 3 document of the http api, keep it in step with the
+// routes and the proto types
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Handler serves the OpenAPI document
+func Handler(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", Spec)
+}
@@ -1,24 +1,140 @@
 package router
 
 import (
+	"sync/atomic"
+
 	"github.com/0x726f6f6b6965/bank/internal/api"
 	"github.com/0x726f6f6b6965/bank/internal/api/middleware"
+	"github.com/0x726f6f6b6965/bank/internal/api/openapi"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+	"github.com/0x726f6f6b6965/bank/internal/ratelimit"
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterRoutes(server *gin.Engine) {
-	RegisterUserRouter(server.Group("/account"))
-	RegisterBankRouter(server.Group("/bank"))
+// Reloader applies the reloadable settings to the registered routes
+type Reloader struct {
+	limiter    *ratelimit.Limiter
+	adminToken atomic.Pointer[string]
+}
+
+// Reload replaces the rate limits and the admin token
+func (r *Reloader) Reload(cfg *config.AppConfig) {
+	r.limiter.Update(cfg.RateLimit)
+	token := cfg.Admin.Token
+	r.adminToken.Store(&token)
+}
+
+// Limiter returns the limiter of the routes, the gRPC server shares it so
+// both transports count against the same buckets and reload together
+func (r *Reloader) Limiter() *ratelimit.Limiter {
+	return r.limiter
+}
+
+func (r *Reloader) token() string {
+	return *r.adminToken.Load()
+}
+
+// RegisterRoutes registers the routes of the handlers serving b, the
+// returned Reloader applies the new rate limits and admin token to them
+func RegisterRoutes(server *gin.Engine, cfg *config.AppConfig, b services.BankInterface, clk clock.Clock, gen ids.Generator) *Reloader {
+	h := api.NewBankAPI(b, cfg, clk, gen)
+	// the services read the request ID from the context of the request
+	server.ContextWithFallback = true
+	server.Use(middleware.RequestID(), middleware.AccessLog(), middleware.Metrics(), middleware.ErrorFormat(cfg.Errors))
+	// the unknown paths and methods get the error format of the routes
+	server.HandleMethodNotAllowed = true
+	server.NoRoute(h.NoRoute)
+	server.NoMethod(h.NoMethod)
+	server.GET("/openapi.json", openapi.Handler)
+	server.GET("/metrics", gin.WrapH(metrics.Handler()))
+	server.GET("/healthz", h.Healthz)
+	server.GET("/readyz", h.Readyz)
+	reloader := &Reloader{limiter: ratelimit.New(cfg.RateLimit, clk)}
+	reloader.Reload(cfg)
+	RegisterUserRouter(server.Group("/account"), h, reloader.limiter)
+	RegisterBankRouter(server.Group("/bank"), h, cfg, reloader.limiter)
+	RegisterAdminRouter(server.Group("/admin"), h, cfg, reloader.token)
+	RegisterV1Router(server.Group("/v1"), h, reloader.limiter)
+	return reloader
+}
+
+// RegisterBankRouter registers the bank routes, the /v1 routes replace the
+// deprecated ones
+func RegisterBankRouter(router *gin.RouterGroup, h *api.BankAPI, cfg *config.AppConfig, limiter *ratelimit.Limiter) {
+	router.Use(middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter))
+	router.GET("/events", h.Events(cfg.Events.Heartbeat))
+	router.GET("/balance", middleware.Deprecated("/v1/accounts/{id}/balance"), h.GetBalance)
+	router.POST("/transfer", middleware.Deprecated("/v1/accounts/{id}/transfers"), h.Transfer)
+	router.GET("/transactions", middleware.Deprecated("/v1/accounts/{id}/transactions"), h.GetTransactions)
+}
+
+func RegisterV1Router(router *gin.RouterGroup, h *api.BankAPI, limiter *ratelimit.Limiter) {
+	router.Use(middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter))
+	RegisterAccountRouter(router.Group("/accounts/:id"), h)
+	router.GET("/transactions/:id", h.GetTransaction)
+}
+
+func RegisterAccountRouter(router *gin.RouterGroup, h *api.BankAPI) {
+	router.Use(middleware.AccountOwner("id"))
+	router.GET("/balance", h.GetBalance)
+	router.GET("/transactions", h.GetTransactions)
+	router.POST("/deposits", h.Deposit)
+	router.POST("/withdrawals", h.Withdraw)
+	router.POST("/transfers", h.CreateTransfer)
+	RegisterWebhookRouter(router.Group("/webhooks"), h)
+}
+
+func RegisterWebhookRouter(router *gin.RouterGroup, h *api.BankAPI) {
+	router.POST("", h.CreateWebhook)
+	router.GET("", h.GetWebhooks)
+	router.DELETE("/:webhook", h.DeleteWebhook)
+	router.GET("/:webhook/deliveries", h.GetDeliveries)
+	router.GET("/:webhook/dead-letters", h.GetDeadLetters)
+	router.POST("/:webhook/dead-letters/:delivery/retry", h.RetryDeadLetter)
+}
+
+// RegisterUserRouter registers the account routes, the login, the
+// registration and the password reset are limited per IP as the auth group
+func RegisterUserRouter(router *gin.RouterGroup, h *api.BankAPI, limiter *ratelimit.Limiter) {
+	auth := middleware.RateLimit(limiter, ratelimit.GroupAuth)
+	router.POST("/nonce", auth, h.GetToken)
+	router.POST("/register", auth, h.CreateAccount)
+	router.POST("/logout", middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter), h.Logout)
+	router.POST("/logout/all", middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter), h.LogoutAll)
+	RegisterTOTPRouter(router.Group("/totp"), h, limiter)
+	RegisterPasswordRouter(router.Group("/password"), h, limiter)
+	RegisterSessionRouter(router.Group("/sessions"), h, limiter)
+}
+
+func RegisterSessionRouter(router *gin.RouterGroup, h *api.BankAPI, limiter *ratelimit.Limiter) {
+	router.Use(middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter))
+	router.GET("", h.GetSessions)
+	router.DELETE("/:id", h.RevokeSession)
+}
+
+func RegisterPasswordRouter(router *gin.RouterGroup, h *api.BankAPI, limiter *ratelimit.Limiter) {
+	auth := middleware.RateLimit(limiter, ratelimit.GroupAuth)
+	router.POST("", middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter), h.ChangePassword)
+	router.POST("/reset", auth, h.RequestPasswordReset)
+	router.POST("/reset/confirm", auth, h.ResetPassword)
 }
 
-func RegisterBankRouter(router *gin.RouterGroup) {
-	router.Use(middleware.UserAuthorization())
-	router.GET("/balance", api.BankAPI.GetBalance)
-	router.POST("/transfer", api.BankAPI.Transfer)
-	router.GET("/transactions", api.BankAPI.GetTransactions)
+func RegisterTOTPRouter(router *gin.RouterGroup, h *api.BankAPI, limiter *ratelimit.Limiter) {
+	router.Use(middleware.UserAuthorization(h.Bank(), h.Clock()), middleware.RateLimitAccess(limiter))
+	router.POST("/enroll", h.EnrollTOTP)
+	router.POST("/verify", h.ConfirmTOTP)
 }
 
-func RegisterUserRouter(router *gin.RouterGroup) {
-	router.POST("/nonce", api.BankAPI.GetToken)
-	router.POST("/register", api.BankAPI.CreateAccount)
+// RegisterAdminRouter registers the admin routes, they also need a client
+// certificate when the server verifies them
+func RegisterAdminRouter(router *gin.RouterGroup, h *api.BankAPI, cfg *config.AppConfig, token func() string) {
+	if cfg.Server.TLS.ClientCAFile != "" {
+		router.Use(middleware.ClientCertificate())
+	}
+	router.Use(middleware.AdminAuthorization(token))
+	router.POST("/accounts/:account/unlock", h.UnlockAccount)
 }
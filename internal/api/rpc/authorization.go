@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc/bankpb"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/logging"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// publicMethods do not need a token
+var publicMethods = map[string]bool{
+	bankpb.Bank_CreateAccount_FullMethodName:        true,
+	bankpb.Bank_GetToken_FullMethodName:             true,
+	bankpb.Bank_RequestPasswordReset_FullMethodName: true,
+	bankpb.Bank_ResetPassword_FullMethodName:        true,
+	grpc_health_v1.Health_Check_FullMethodName:      true,
+	grpc_health_v1.Health_Watch_FullMethodName:      true,
+}
+
+// requestIDMetadata carries the request ID like the X-Request-ID header
+const requestIDMetadata = "x-request-id"
+
+type tokenKey struct{}
+
+// UnaryAuthorization verifies the bearer token of the authorization
+// metadata like the http middleware does, the token of a valid call is
+// kept in the context of the handler, the expiry of the tokens is checked
+// at the time of clk
+func UnaryAuthorization(b services.BankInterface, clk clock.Clock) grpc.UnaryServerInterceptor {
+	clk = clock.Or(clk)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = withCaller(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, logging.RequestID(ctx)))
+		if publicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		token, err := authorize(ctx, b, clk.Now())
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return handler(context.WithValue(ctx, tokenKey{}, token), req)
+	}
+}
+
+// StreamAuthorization is UnaryAuthorization of the streaming calls
+func StreamAuthorization(b services.BankInterface, clk clock.Clock) grpc.StreamServerInterceptor {
+	clk = clock.Or(clk)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		caller := &callerStream{ServerStream: ss, ctx: withCaller(ss.Context())}
+		caller.SetHeader(metadata.Pairs(requestIDMetadata, logging.RequestID(caller.ctx)))
+		if publicMethods[info.FullMethod] {
+			return handler(srv, caller)
+		}
+		token, err := authorize(caller.ctx, b, clk.Now())
+		if err != nil {
+			return toStatus(err)
+		}
+		caller.ctx = context.WithValue(caller.ctx, tokenKey{}, token)
+		return handler(srv, caller)
+	}
+}
+
+// callerStream is a stream with the context of withCaller
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *callerStream) Context() context.Context {
+	return s.ctx
+}
+
+func authorize(ctx context.Context, b services.BankInterface, now time.Time) (*proto.UserToken, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	token, err := utils.CheckTokenString(utils.BearerToken(header), now)
+	if err != nil {
+		if !errors.Is(err, utils.ErrTokenExpire) {
+			err = services.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if err := b.VerifyToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// withCaller adds the IP, the user agent and the request ID of the caller
+// to ctx, a request ID is assigned when the caller sent none
+func withCaller(ctx context.Context) context.Context {
+	if ip := peerIP(ctx); ip != "" {
+		ctx = services.WithClientIP(ctx, ip)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		ctx = services.WithUserAgent(ctx, ua[0])
+	}
+	id := uuid.NewString()
+	if ids := md.Get(requestIDMetadata); len(ids) > 0 && logging.ValidRequestID(ids[0]) {
+		id = ids[0]
+	}
+	return logging.WithRequestID(ctx, id)
+}
+
+// peerIP returns the IP of the peer of the call, the servers do not read
+// forwarding metadata so the caller can not pick it
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+func tokenFrom(ctx context.Context) (*proto.UserToken, error) {
+	token, ok := ctx.Value(tokenKey{}).(*proto.UserToken)
+	if !ok || token == nil {
+		return nil, services.ErrInvalidToken
+	}
+	return token, nil
+}
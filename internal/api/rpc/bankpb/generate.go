@@ -0,0 +1,5 @@
+// Package bankpb holds the protobuf definition of the gRPC api and the
+// stubs generated from it
+package bankpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative bank.proto
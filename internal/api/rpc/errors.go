@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain - the domain of the ErrorInfo detail of every error
+const ErrorDomain = "bank"
+
+// codeOf maps the HTTP status of the error catalog to a gRPC code
+var codeOf = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusLocked:              codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// toStatus converts err to a gRPC status error, the catalog code is kept
+// as the reason of an ErrorInfo detail and the fields of a validation
+// error as a BadRequest detail
+func toStatus(err error) error {
+	e := apierr.Lookup(err)
+	code, ok := codeOf[e.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	msg := err.Error()
+	if code == codes.Internal {
+		msg = apierr.ErrInternal.Error()
+	}
+
+	st := status.New(code, msg)
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.Code, Domain: ErrorDomain}); err == nil {
+		st = withInfo
+	}
+
+	var verr *policy.ValidationError
+	if errors.As(err, &verr) {
+		br := &errdetails.BadRequest{}
+		for _, f := range verr.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Message,
+			})
+		}
+		if withFields, err := st.WithDetails(br); err == nil {
+			st = withFields
+		}
+	}
+	return st.Err()
+}
+
+// Reason returns the catalog code carried by a status error of the server
+func Reason(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == ErrorDomain {
+			return info.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc/bankpb"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// health answers the standard health checks with the readiness of the
+// bank, the empty service and "bank.v1.Bank" are known
+type health struct {
+	grpc_health_v1.UnimplementedHealthServer
+	bank services.BankInterface
+}
+
+func (h *health) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	switch req.GetService() {
+	case "", bankpb.Bank_ServiceDesc.ServiceName:
+	default:
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	resp := &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}
+	if err := h.bank.Ready(ctx); err != nil {
+		resp.Status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return resp, nil
+}
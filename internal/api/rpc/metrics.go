@@ -0,0 +1,22 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryMetrics counts the calls and observes their duration by method and code
+func UnaryMetrics() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		code := status.Code(err).String()
+		metrics.GRPCRequests.WithLabelValues(info.FullMethod, code).Inc()
+		metrics.GRPCDuration.WithLabelValues(info.FullMethod, code).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc/bankpb"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// retryAfterMetadata carries the wait of a rejected call like the
+// Retry-After header
+const retryAfterMetadata = "retry-after"
+
+// methodGroups are the rate limit groups of the methods, they match the
+// groups of the http routes. The other methods of the bank are writes and
+// the health checks are not limited.
+var methodGroups = map[string]string{
+	bankpb.Bank_CreateAccount_FullMethodName:        ratelimit.GroupAuth,
+	bankpb.Bank_GetToken_FullMethodName:             ratelimit.GroupAuth,
+	bankpb.Bank_RequestPasswordReset_FullMethodName: ratelimit.GroupAuth,
+	bankpb.Bank_ResetPassword_FullMethodName:        ratelimit.GroupAuth,
+	bankpb.Bank_GetBalance_FullMethodName:           ratelimit.GroupRead,
+	bankpb.Bank_GetTransactions_FullMethodName:      ratelimit.GroupRead,
+	bankpb.Bank_GetTransaction_FullMethodName:       ratelimit.GroupRead,
+	bankpb.Bank_GetSessions_FullMethodName:          ratelimit.GroupRead,
+}
+
+func groupOf(method string) (string, bool) {
+	if group, ok := methodGroups[method]; ok {
+		return group, true
+	}
+	if strings.HasPrefix(method, "/"+bankpb.Bank_ServiceDesc.ServiceName+"/") {
+		return ratelimit.GroupWrite, true
+	}
+	return "", false
+}
+
+// UnaryRateLimit limits the calls per peer IP, and per account once
+// UnaryAuthorization ran, with the buckets of the http routes when the
+// limiter is shared. A rejected call gets ResourceExhausted with the
+// retry-after header in seconds.
+func UnaryRateLimit(limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if wait, err := limit(ctx, limiter, info.FullMethod); err != nil {
+			grpc.SetHeader(ctx, metadata.Pairs(retryAfterMetadata, wait))
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimit is UnaryRateLimit of the streaming calls
+func StreamRateLimit(limiter *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if wait, err := limit(ss.Context(), limiter, info.FullMethod); err != nil {
+			ss.SetHeader(metadata.Pairs(retryAfterMetadata, wait))
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// limit takes a token of the group of the method, it returns the wait in
+// seconds and the status error of a rejected call
+func limit(ctx context.Context, limiter *ratelimit.Limiter, method string) (string, error) {
+	group, ok := groupOf(method)
+	if !ok {
+		return "", nil
+	}
+	keys := []string{"ip:" + peerIP(ctx)}
+	if token, _ := ctx.Value(tokenKey{}).(*proto.UserToken); token != nil {
+		keys = append(keys, "account:"+token.Account)
+	}
+	wait, ok := limiter.Allow(ctx, group, keys...)
+	if ok {
+		return "", nil
+	}
+	return strconv.Itoa(int(math.Ceil(wait.Seconds()))), toStatus(apierr.ErrRateLimited)
+}
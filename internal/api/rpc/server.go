@@ -0,0 +1,312 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/api"
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc/bankpb"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/ratelimit"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// NewServer returns a gRPC server of the bank and its health checks with
+// the tracing, the metrics, the authorization and the rate limit
+// interceptors installed. The calls share the buckets of the http routes
+// when limiter is the one of the router, a nil limiter is built from cfg.
+func NewServer(b services.BankInterface, cfg *config.AppConfig, clk clock.Clock, gen ids.Generator, limiter *ratelimit.Limiter, opts ...grpc.ServerOption) *grpc.Server {
+	tokenTTL := cfg.JWT.TokenTTL
+	if tokenTTL <= 0 {
+		tokenTTL = api.TokenTTL
+	}
+	clk = clock.Or(clk)
+	if limiter == nil {
+		limiter = ratelimit.New(cfg.RateLimit, clk)
+	}
+	opts = append(opts,
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(UnaryMetrics(), UnaryAuthorization(b, clk), UnaryRateLimit(limiter)),
+		grpc.ChainStreamInterceptor(StreamAuthorization(b, clk), StreamRateLimit(limiter)),
+	)
+	s := grpc.NewServer(opts...)
+	bankpb.RegisterBankServer(s, &server{bank: b, tokenTTL: tokenTTL, clock: clk, ids: ids.Or(gen)})
+	grpc_health_v1.RegisterHealthServer(s, &health{bank: b})
+	return s
+}
+
+// server wraps BankInterface, it mirrors the handlers of the http api
+type server struct {
+	bankpb.UnimplementedBankServer
+	bank     services.BankInterface
+	tokenTTL time.Duration
+	clock    clock.Clock
+	ids      ids.Generator
+}
+
+func (s *server) CreateAccount(ctx context.Context, req *bankpb.CreateAccountRequest) (*bankpb.User, error) {
+	user, err := s.bank.CreateAccount(ctx, proto.User{
+		Account:  s.ids.NewID(),
+		Password: req.GetPassword(),
+		Name:     req.GetName(),
+		Balance:  int(req.GetBalance()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.User{
+		Account:   user.Account,
+		Name:      user.Name,
+		Balance:   int64(user.Balance),
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}, nil
+}
+
+func (s *server) GetToken(ctx context.Context, req *bankpb.GetTokenRequest) (*bankpb.GetTokenResponse, error) {
+	nonce, err := s.bank.GetNonce(ctx, req.GetAccount(), req.GetPassword(), req.GetTotp())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	token, metadata, err := utils.GenerateNewAccessToken(s.ids.NewID(), req.GetAccount(), nonce, s.clock.Now(), s.tokenTTL)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.TrackToken(ctx, metadata); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.GetTokenResponse{Token: token}, nil
+}
+
+func (s *server) GetBalance(ctx context.Context, req *bankpb.GetBalanceRequest) (*bankpb.GetBalanceResponse, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	balance, err := s.bank.GetBalance(ctx, token.Account)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.GetBalanceResponse{Balance: int64(balance)}, nil
+}
+
+func (s *server) Deposit(ctx context.Context, req *bankpb.DepositRequest) (*bankpb.TransactionResponse, error) {
+	return s.transact(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionDeposit,
+		To:     req.GetTo(),
+		Amount: int(req.GetAmount()),
+	})
+}
+
+func (s *server) Withdraw(ctx context.Context, req *bankpb.WithdrawRequest) (*bankpb.TransactionResponse, error) {
+	return s.transact(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionWithdraw,
+		From:   req.GetFrom(),
+		Amount: int(req.GetAmount()),
+		TOTP:   req.GetTotp(),
+	})
+}
+
+func (s *server) Transfer(ctx context.Context, req *bankpb.TransferRequest) (*bankpb.TransactionResponse, error) {
+	return s.transact(ctx, proto.TransactionRequest{
+		Action: proto.TransactionActionTransfer,
+		From:   req.GetFrom(),
+		To:     req.GetTo(),
+		Amount: int(req.GetAmount()),
+		TOTP:   req.GetTotp(),
+	})
+}
+
+// transact runs the request like the transfer handler of the http api
+func (s *server) transact(ctx context.Context, param proto.TransactionRequest) (*bankpb.TransactionResponse, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := api.ValidateTransaction(param); err != nil {
+		return nil, toStatus(err)
+	}
+	if param.Action != proto.TransactionActionDeposit {
+		if err := s.bank.StepUp(ctx, token.Account, param.Amount, param.TOTP); err != nil {
+			return nil, toStatus(err)
+		}
+	}
+
+	tx := proto.Transaction{
+		From:   param.From,
+		To:     param.To,
+		Amount: param.Amount,
+	}
+	var result *proto.Transaction
+	switch param.Action {
+	case proto.TransactionActionDeposit:
+		result, _, err = s.bank.Deposit(ctx, tx, token.Nonce)
+	case proto.TransactionActionWithdraw:
+		result, _, err = s.bank.Withdraw(ctx, tx, token.Nonce)
+	default:
+		result, _, err = s.bank.Transaction(ctx, tx, token.Nonce)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.TransactionResponse{Id: result.ID}, nil
+}
+
+func (s *server) GetTransactions(ctx context.Context, req *bankpb.GetTransactionsRequest) (*bankpb.GetTransactionsResponse, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	txs, err := s.bank.GetTransactions(ctx, token.Account)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &bankpb.GetTransactionsResponse{
+		Transactions: make([]*bankpb.Transaction, 0, len(txs)),
+	}
+	for _, tx := range txs {
+		resp.Transactions = append(resp.Transactions, toTransaction(tx))
+	}
+	return resp, nil
+}
+
+func (s *server) GetTransaction(ctx context.Context, req *bankpb.GetTransactionRequest) (*bankpb.Transaction, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	tx, err := s.bank.GetTransaction(ctx, token.Account, req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTransaction(*tx), nil
+}
+
+func toTransaction(tx proto.Transaction) *bankpb.Transaction {
+	return &bankpb.Transaction{
+		Id:        tx.ID,
+		From:      tx.From,
+		To:        tx.To,
+		Amount:    int64(tx.Amount),
+		State:     bankpb.TransactionState(tx.State),
+		CreatedAt: tx.CreatedAt,
+	}
+}
+
+func (s *server) EnrollTOTP(ctx context.Context, req *bankpb.EnrollTOTPRequest) (*bankpb.TOTPEnrollment, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	enrollment, err := s.bank.EnrollTOTP(ctx, token.Account)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.TOTPEnrollment{
+		Secret:        enrollment.Secret,
+		Uri:           enrollment.URI,
+		RecoveryCodes: enrollment.RecoveryCodes,
+	}, nil
+}
+
+func (s *server) ConfirmTOTP(ctx context.Context, req *bankpb.ConfirmTOTPRequest) (*bankpb.Empty, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.ConfirmTOTP(ctx, token.Account, req.GetCode()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) ChangePassword(ctx context.Context, req *bankpb.ChangePasswordRequest) (*bankpb.Empty, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.ChangePassword(ctx, token.Account, req.GetOldPassword(), req.GetNewPassword()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) RequestPasswordReset(ctx context.Context, req *bankpb.RequestPasswordResetRequest) (*bankpb.Empty, error) {
+	if err := s.bank.RequestPasswordReset(ctx, req.GetAccount()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) ResetPassword(ctx context.Context, req *bankpb.ResetPasswordRequest) (*bankpb.Empty, error) {
+	if err := s.bank.ResetPassword(ctx, req.GetToken(), req.GetNewPassword()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) Logout(ctx context.Context, req *bankpb.LogoutRequest) (*bankpb.Empty, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.Logout(ctx, token); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) LogoutAll(ctx context.Context, req *bankpb.LogoutAllRequest) (*bankpb.Empty, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.LogoutAll(ctx, token.Account); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
+
+func (s *server) GetSessions(ctx context.Context, req *bankpb.GetSessionsRequest) (*bankpb.GetSessionsResponse, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	sessions, err := s.bank.GetSessions(ctx, token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &bankpb.GetSessionsResponse{
+		Sessions: make([]*bankpb.Session, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &bankpb.Session{
+			Id:         session.ID,
+			Ip:         session.IP,
+			UserAgent:  session.UserAgent,
+			Current:    session.Current,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpireAt:   session.ExpireAt,
+		})
+	}
+	return resp, nil
+}
+
+func (s *server) RevokeSession(ctx context.Context, req *bankpb.RevokeSessionRequest) (*bankpb.Empty, error) {
+	token, err := tokenFrom(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.bank.RevokeSession(ctx, token.Account, req.GetId()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &bankpb.Empty{}, nil
+}
@@ -0,0 +1,233 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/api/rpc/bankpb"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ratelimit"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newClient(t *testing.T) bankpb.BankClient {
+	return bankpb.NewBankClient(newConn(t))
+}
+
+func newConn(t *testing.T) *grpc.ClientConn {
+	return newConnWith(t, &config.AppConfig{Env: config.Dev}, nil, nil)
+}
+
+// newConnWith returns a connection to a server of cfg telling the time
+// with clk and limiting the calls with limiter
+func newConnWith(t *testing.T, cfg *config.AppConfig, clk clock.Clock, limiter *ratelimit.Limiter) *grpc.ClientConn {
+	b, err := services.NewBank(cfg, clk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Close(context.Background())
+	})
+	listener := bufconn.Listen(1 << 20)
+	s := NewServer(b, cfg, clk, nil, limiter)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// login registers an account, the returned function issues a token of it,
+// every write rotates the nonce so a token is good for a single write
+func login(t *testing.T, client bankpb.BankClient, balance int64) (*bankpb.User, func() context.Context) {
+	pwd := uuid.NewString()
+	user, err := client.CreateAccount(context.Background(), &bankpb.CreateAccountRequest{
+		Name:     "grpc-user",
+		Password: pwd,
+		Balance:  balance,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return user, func() context.Context {
+		resp, err := client.GetToken(context.Background(), &bankpb.GetTokenRequest{
+			Account:  user.Account,
+			Password: pwd,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+resp.Token)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	client := newClient(t)
+
+	_, err := client.GetBalance(context.Background(), &bankpb.GetBalanceRequest{})
+	if status.Code(err) != codes.Unauthenticated || Reason(err) != "INVALID_TOKEN" {
+		t.Fatalf("Expected error: %v, got: %v", codes.Unauthenticated, err)
+	}
+
+	_, token := login(t, client, 100)
+	ctx := token()
+	var header metadata.MD
+	resp, err := client.GetBalance(metadata.AppendToOutgoingContext(ctx, "x-request-id", "test-request"),
+		&bankpb.GetBalanceRequest{}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Balance != 100 {
+		t.Fatalf("Expected balance: %v, got: %v", 100, resp.Balance)
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "test-request" {
+		t.Fatalf("Expected request id: %v, got: %v", "test-request", ids)
+	}
+
+	if _, err := client.Logout(ctx, &bankpb.LogoutRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = client.GetBalance(ctx, &bankpb.GetBalanceRequest{})
+	if status.Code(err) != codes.Unauthenticated || Reason(err) != "TOKEN_REVOKED" {
+		t.Fatalf("Expected error: %v, got: %v", codes.Unauthenticated, err)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	cfg := &config.AppConfig{Env: config.Dev, JWT: config.JWTConfig{TokenTTL: time.Hour}}
+	client := bankpb.NewBankClient(newConnWith(t, cfg, clk, nil))
+	_, token := login(t, client, 100)
+	ctx := token()
+
+	// the token lives as long as jwt.token_ttl
+	clk.Advance(time.Hour)
+	if _, err := client.GetBalance(ctx, &bankpb.GetBalanceRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clk.Advance(time.Second)
+	_, err := client.GetBalance(ctx, &bankpb.GetBalanceRequest{})
+	if status.Code(err) != codes.Unauthenticated || Reason(err) != "TOKEN_EXPIRED" {
+		t.Fatalf("Expected error: %v, got: %v", codes.Unauthenticated, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &config.AppConfig{
+		Env: config.Dev,
+		RateLimit: config.RateLimitConfig{
+			Auth: config.RateLimitRule{Requests: 2, Period: time.Hour},
+			Read: config.RateLimitRule{Requests: 1, Period: time.Hour},
+		},
+	}
+	limiter := ratelimit.New(cfg.RateLimit, nil)
+	client := bankpb.NewBankClient(newConnWith(t, cfg, nil, limiter))
+	_, token := login(t, client, 100)
+	ctx := token()
+
+	// the registration and the login took the tokens of the auth group
+	var header metadata.MD
+	_, err := client.GetToken(context.Background(), &bankpb.GetTokenRequest{Account: "a", Password: "p"}, grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted || Reason(err) != "RATE_LIMITED" {
+		t.Fatalf("Expected error: %v, got: %v", codes.ResourceExhausted, err)
+	}
+	if wait := header.Get("retry-after"); len(wait) != 1 || wait[0] != "1800" {
+		t.Fatalf("Expected retry-after: 1800, got: %v", wait)
+	}
+
+	if _, err := client.GetBalance(ctx, &bankpb.GetBalanceRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = client.GetBalance(ctx, &bankpb.GetBalanceRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected error: %v, got: %v", codes.ResourceExhausted, err)
+	}
+
+	// a reload of the limiter applies to the running server
+	cfg.RateLimit.Read = config.RateLimitRule{}
+	limiter.Update(cfg.RateLimit)
+	if _, err := client.GetBalance(ctx, &bankpb.GetBalanceRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	client := grpc_health_v1.NewHealthClient(newConn(t))
+
+	resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected status: %v, got: %v", grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+	}
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected error: %v, got: %v", codes.NotFound, err)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	client := newClient(t)
+	from, token := login(t, client, 100)
+	to, _ := login(t, client, 10)
+
+	if _, err := client.Deposit(token(), &bankpb.DepositRequest{To: from.Account, Amount: 50}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := client.Transfer(token(), &bankpb.TransferRequest{From: from.Account, To: to.Account, Amount: 30}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx := token()
+	_, err := client.Withdraw(ctx, &bankpb.WithdrawRequest{From: from.Account, Amount: 1000})
+	if status.Code(err) != codes.FailedPrecondition || Reason(err) != "INSUFFICIENT_BALANCE" {
+		t.Fatalf("Expected error: %v, got: %v", codes.FailedPrecondition, err)
+	}
+
+	_, err = client.Deposit(ctx, &bankpb.DepositRequest{To: from.Account})
+	if status.Code(err) != codes.InvalidArgument || Reason(err) != "VALIDATION_FAILED" {
+		t.Fatalf("Expected error: %v, got: %v", codes.InvalidArgument, err)
+	}
+
+	resp, err := client.GetTransactions(ctx, &bankpb.GetTransactionsRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resp.Transactions) != 2 {
+		t.Fatalf("Expected transactions: %v, got: %v", 2, len(resp.Transactions))
+	}
+	tx, err := client.GetTransaction(ctx, &bankpb.GetTransactionRequest{Id: resp.Transactions[1].Id})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tx.To != to.Account || tx.State != bankpb.TransactionState_TRANSACTION_STATE_SUCCESS {
+		t.Fatalf("Expected transfer to: %v, got: %v", to.Account, tx)
+	}
+	balance, err := client.GetBalance(ctx, &bankpb.GetBalanceRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if balance.Balance != 120 {
+		t.Fatalf("Expected balance: %v, got: %v", 120, balance.Balance)
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/audit"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/logging"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// Auditor records security relevant events, it returns the error of an
+// event which could not be recorded
+type Auditor interface {
+	Record(ctx context.Context, event proto.AuditEvent) error
+}
+
+type logAuditor struct{}
+
+// NewLogAuditor returns an auditor that writes every event to the default logger
+func NewLogAuditor() Auditor {
+	return &logAuditor{}
+}
+
+func (a *logAuditor) Record(ctx context.Context, event proto.AuditEvent) error {
+	slog.InfoContext(ctx, "audit",
+		"type", event.Type,
+		"account", event.Account,
+		"ip", event.IP,
+		"detail", event.Detail,
+		"created_at", event.CreatedAt,
+	)
+	return nil
+}
+
+type chainAuditor struct {
+	chain *audit.Chain
+}
+
+// NewChainAuditor returns an auditor that appends every event to the chain
+// and logs it with its place in the chain
+func NewChainAuditor(chain *audit.Chain) Auditor {
+	return &chainAuditor{chain: chain}
+}
+
+// NewAuditor returns the chain auditor of the configured file, the existing
+// entries are verified first so a broken log stops the service
+func NewAuditor(cfg config.AuditConfig) (Auditor, error) {
+	if cfg.Path == "" {
+		return NewChainAuditor(audit.NewChain(io.Discard, 0, audit.Genesis)), nil
+	}
+	chain, err := audit.Open(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return NewChainAuditor(chain), nil
+}
+
+func (a *chainAuditor) Record(ctx context.Context, event proto.AuditEvent) error {
+	entry, err := a.chain.Append(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "audit append failed", "type", event.Type, "account", event.Account, "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "audit",
+		"type", event.Type,
+		"account", event.Account,
+		"ip", event.IP,
+		"detail", event.Detail,
+		"seq", entry.Seq,
+		"hash", entry.Hash,
+		"created_at", event.CreatedAt,
+	)
+	return nil
+}
+
+// Err returns the error of the last failed append
+func (a *chainAuditor) Err() error {
+	return a.chain.Err()
+}
+
+// Close flushes and closes the file of the chain
+func (a *chainAuditor) Close() error {
+	return a.chain.Close()
+}
+
+func (b *bank) record(ctx context.Context, event proto.AuditEvent) error {
+	if b.auditor == nil {
+		return nil
+	}
+	stamp(ctx, &event, b.now())
+	return b.auditor.Record(ctx, event)
+}
+
+// recordTransaction records a transaction before it is committed, the
+// caller holds the lock of the transactions so the entries follow the order
+// of the transactions. A transaction whose entry can not be written is
+// refused, no money moves without its entry in the audit log.
+func (b *bank) recordTransaction(ctx context.Context, eventType, account string, tx proto.Transaction) error {
+	err := b.record(ctx, proto.AuditEvent{
+		Type:        eventType,
+		Account:     account,
+		IP:          clientIP(ctx),
+		Transaction: &tx,
+	})
+	if err != nil {
+		return ErrStorageDown
+	}
+	return nil
+}
+
+// recordNonceRotation records the rotation of the nonce which authorized a
+// committed transaction, the transaction is not undone when it fails
+func (b *bank) recordNonceRotation(ctx context.Context, eventType, account string) {
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditNonceRotated,
+		Account: account,
+		Detail:  eventType,
+	})
+}
+
+// stamp sets the time and the request ID of an event, an event without a
+// time happened at now
+func stamp(ctx context.Context, event *proto.AuditEvent, now time.Time) {
+	if event.CreatedAt == 0 {
+		event.CreatedAt = now.Unix()
+	}
+	if event.RequestID == "" {
+		event.RequestID = logging.RequestID(ctx)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/audit"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/logging"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+func TestChainAuditor(t *testing.T) {
+	buf := &bytes.Buffer{}
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.auditor = NewChainAuditor(audit.NewChain(buf, 0, audit.Genesis))
+	service.guard = newLoginGuard(config.LockoutConfig{MaxAttempts: 5}, service.auditor)
+	service.users.data["test"] = proto.User{Account: "test", Balance: 100, Password: "test-pwd"}
+
+	reqCtx := logging.WithRequestID(ctx, "req-1")
+	nonce, err := service.GetNonce(reqCtx, "test", "test-pwd", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, _, err := service.Transaction(reqCtx, proto.Transaction{From: "test", To: "test2", Amount: 10}, nonce); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	service.GetNonce(reqCtx, "test", "wrong", "")
+	if err := service.UnlockAccount(reqCtx, "test"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	_, n, err := audit.Verify(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{
+		proto.AuditLoginSucceeded,
+		proto.AuditNonceRotated,
+		proto.AuditTransfer,
+		proto.AuditNonceRotated,
+		proto.AuditLoginFailed,
+		proto.AuditAccountUnlock,
+	}
+	if n != len(expected) {
+		t.Fatalf("Expected entries: %d, got: %d", len(expected), n)
+	}
+	for i, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry struct {
+			Event proto.AuditEvent `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if entry.Event.Type != expected[i] {
+			t.Fatalf("Expected event %d: %s, got: %s", i+1, expected[i], entry.Event.Type)
+		}
+		if entry.Event.RequestID != "req-1" || entry.Event.CreatedAt == 0 {
+			t.Fatalf("Expected the request ID and the time of event %d, got: %+v", i+1, entry.Event)
+		}
+		if entry.Event.Type == proto.AuditTransfer && (entry.Event.Transaction == nil || entry.Event.Transaction.Amount != 10) {
+			t.Fatalf("Expected the transaction of the transfer, got: %+v", entry.Event.Transaction)
+		}
+	}
+}
+
+func TestAuditFailure(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.auditor = NewChainAuditor(audit.NewChain(failingWriter{}, 0, audit.Genesis))
+
+	_, _, err := service.Deposit(ctx, proto.Transaction{To: "test", Amount: 10}, "test-nonce")
+	if !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+	_, _, err = service.Transaction(ctx, proto.Transaction{From: "test", To: "test2", Amount: 10}, "test-nonce")
+	if !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+
+	// no money moved without its entry
+	if user := service.users.data["test"]; user.Balance != 100 || user.Nonce != "test-nonce" {
+		t.Fatalf("Expected the account unchanged, got: %+v", user)
+	}
+	if user := service.users.data["test2"]; user.Balance != 10 {
+		t.Fatalf("Expected the account unchanged, got: %+v", user)
+	}
+	if len(service.txs.data) != 0 || service.outbox.Pending() != 0 {
+		t.Fatalf("Expected no transaction, got: %v", service.txs.data)
+	}
+}
+
+func TestAuditRefusedLogin(t *testing.T) {
+	buf := &bytes.Buffer{}
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.auditor = NewChainAuditor(audit.NewChain(buf, 0, audit.Genesis))
+	service.guard = newLoginGuard(config.LockoutConfig{MaxAttempts: 1}, service.auditor)
+	service.users.data["test"] = proto.User{Account: "test", Balance: 100, Password: "test-pwd"}
+
+	service.GetNonce(ctx, "test", "wrong", "")
+	_, err := service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+
+	expected := []string{
+		proto.AuditLoginFailed,
+		proto.AuditAccountLocked,
+		proto.AuditLoginRefused,
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected entries: %d, got: %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		var entry struct {
+			Event proto.AuditEvent `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if entry.Event.Type != expected[i] {
+			t.Fatalf("Expected event %d: %s, got: %s", i+1, expected[i], entry.Event.Type)
+		}
+	}
+}
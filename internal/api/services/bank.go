@@ -3,18 +3,21 @@ package services
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
 	"github.com/0x726f6f6b6965/bank/internal/proto"
 	"github.com/0x726f6f6b6965/bank/internal/utils"
 )
 
 var (
-	bankService  *bank
-	onceInitBank sync.Once
-
 	NonceLen = 10
 
 	ErrEmptyAccount     = errors.New("account is empty")
@@ -27,13 +30,52 @@ var (
 	ErrBalanceNotEnough = errors.New("balance is not enough")
 	ErrFromAccount      = errors.New("from account is not correct")
 	ErrToAccount        = errors.New("to account is not correct")
+	ErrSameAccount      = errors.New("from and to account are the same")
+	ErrAccountLocked    = errors.New("account is locked")
+	ErrTooManyAttempts  = errors.New("too many failed attempts, try again later")
+	ErrTOTPRequired     = errors.New("totp code is required")
+	ErrTOTPInvalid      = errors.New("totp code is not correct")
+	ErrTOTPEnrolled     = errors.New("totp already enrolled")
+	ErrTOTPNotEnrolled  = errors.New("totp not enrolled")
+	ErrResetToken       = errors.New("reset token is invalid or expired")
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrTokenRevoked     = errors.New("token is revoked")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrTxNotFound       = errors.New("transaction not found")
+	ErrEventsDisabled   = errors.New("events are disabled")
+	ErrWebhooksDisabled = errors.New("webhooks are disabled")
+	ErrWebhookURL       = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookTarget    = errors.New("webhook url must resolve to public addresses")
+	ErrWebhookEvents    = errors.New("webhook events must list known event types")
+	ErrTooManyWebhooks  = errors.New("too many webhooks")
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("dead letter not found")
+	ErrShuttingDown     = errors.New("service is shutting down")
+	ErrStorageDown      = errors.New("storage is unavailable")
 )
 
 type bank struct {
-	users  *userMap
-	txs    *txMap
-	count  uint64
-	search *search
+	users    *userMap
+	txs      *txMap
+	count    uint64
+	search   *search
+	guard    *loginGuard
+	totps    *totpMap
+	resets   *resetMap
+	tokens   *tokenStore
+	events   *eventBus
+	webhooks *webhooks
+	outbox   *outbox
+	relay    *relay
+	auditor  Auditor
+	notifier Notifier
+	policy   *policy.Policy
+	// clock and ids - the time and the IDs of the bank, the system clock
+	// and random UUIDs when nil
+	clock clock.Clock
+	ids   ids.Generator
+	// draining - the service stopped taking new work for a shutdown
+	draining atomic.Bool
 }
 
 type userMap struct {
@@ -51,29 +93,90 @@ type BankInterface interface {
 	Deposit(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error)
 	Withdraw(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error)
 	Transaction(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error)
-	GetNonce(ctx context.Context, account, pwd string) (string, error)
+	GetNonce(ctx context.Context, account, pwd, code string) (string, error)
 	GetBalance(ctx context.Context, account string) (int, error)
 	GetTransactions(ctx context.Context, account string) ([]proto.Transaction, error)
+	GetTransaction(ctx context.Context, account string, id uint64) (*proto.Transaction, error)
+	Subscribe(ctx context.Context, account string, after uint64) (<-chan proto.Event, error)
+	CreateWebhook(ctx context.Context, account string, req proto.CreateWebhookRequest) (*proto.Webhook, error)
+	GetWebhooks(ctx context.Context, account string) ([]proto.Webhook, error)
+	DeleteWebhook(ctx context.Context, account, id string) error
+	GetDeliveries(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error)
+	GetDeadLetters(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error)
+	RetryDeadLetter(ctx context.Context, account, id, deliveryID string) error
+	UnlockAccount(ctx context.Context, account string) error
+	EnrollTOTP(ctx context.Context, account string) (*proto.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, account, code string) error
+	StepUp(ctx context.Context, account string, amount int, code string) error
+	ChangePassword(ctx context.Context, account, oldPwd, newPwd string) error
+	RequestPasswordReset(ctx context.Context, account string) error
+	ResetPassword(ctx context.Context, token, newPwd string) error
+	TrackToken(ctx context.Context, token *proto.UserToken) error
+	VerifyToken(ctx context.Context, token *proto.UserToken) error
+	Logout(ctx context.Context, token *proto.UserToken) error
+	LogoutAll(ctx context.Context, account string) error
+	GetSessions(ctx context.Context, token *proto.UserToken) ([]proto.Session, error)
+	RevokeSession(ctx context.Context, account, id string) error
+	Ready(ctx context.Context) error
+	Drain(ctx context.Context)
+	Close(ctx context.Context) error
 }
 
-func GetBankService() BankInterface {
-	return bankService
+// NewBank returns a new bank of cfg, every call starts an empty bank with
+// its own outbox relay which runs until Close. clk is the time of the bank,
+// of its webhooks and of its relay, gen names the webhooks and their
+// deliveries. nil stands for the system clock and random UUIDs.
+func NewBank(cfg *config.AppConfig, clk clock.Clock, gen ids.Generator) (BankInterface, error) {
+	p, err := policy.New(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := openOutbox(cfg.Outbox.Store)
+	if err != nil {
+		return nil, err
+	}
+	auditor, err := NewAuditor(cfg.Audit)
+	if err != nil {
+		messages.Close()
+		return nil, err
+	}
+	events := newEventBus(cfg.Events)
+	hooks := newWebhooks(cfg.Webhooks, clk, gen)
+	service := &bank{
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		count:    1,
+		search:   NewSearch(),
+		guard:    newLoginGuard(cfg.Lockout, auditor),
+		totps:    newTOTPMap(cfg.TOTP),
+		resets:   newResetMap(cfg.Password.ResetTokenTTL),
+		tokens:   newTokenStore(),
+		events:   events,
+		webhooks: hooks,
+		outbox:   messages,
+		relay:    newRelay(cfg.Outbox, messages, clk, events, hooks, NewPublisher(cfg.Outbox)),
+		auditor:  auditor,
+		notifier: NewNotifier(cfg.Notifier),
+		policy:   p,
+		clock:    clk,
+		ids:      gen,
+	}
+	service.relay.Start()
+	return WithTracing(service), nil
 }
 
-func NewBank() BankInterface {
-	onceInitBank.Do(func() {
-		bankService = &bank{
-			users: &userMap{
-				data: make(map[string]proto.User),
-			},
-			txs: &txMap{
-				data: make(map[uint64]proto.Transaction),
-			},
-			count:  1,
-			search: NewSearch(),
-		}
-	})
-	return bankService
+// now returns the time of the clock of the bank
+func (b *bank) now() time.Time {
+	return clock.Or(b.clock).Now()
+}
+
+// newID returns a new ID of the generator of the bank
+func (b *bank) newID() string {
+	return ids.Or(b.ids).NewID()
 }
 
 func (b *bank) CreateAccount(ctx context.Context, user proto.User) (*proto.User, error) {
@@ -81,16 +184,22 @@ func (b *bank) CreateAccount(ctx context.Context, user proto.User) (*proto.User,
 		return nil, ErrEmptyAccount
 	}
 
-	if utils.IsEmpty(user.Password) {
-		return nil, ErrEmptyPwd
-	}
-
 	if utils.IsEmpty(user.Name) {
 		user.Name = "anonymous"
 	}
 
+	errs := &policy.ValidationError{}
+	if utils.IsEmpty(user.Password) {
+		errs.Add("password", ErrEmptyPwd)
+	} else {
+		b.policy.ValidatePassword("password", user.Password, errs)
+	}
+	b.policy.ValidateName("name", user.Name, errs)
 	if user.Balance <= 0 {
-		return nil, ErrNegativeBalance
+		errs.Add("balance", ErrNegativeBalance)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
 	}
 
 	nonce, err := utils.GenerateNonce(NonceLen)
@@ -100,8 +209,8 @@ func (b *bank) CreateAccount(ctx context.Context, user proto.User) (*proto.User,
 
 	user.Nonce = nonce
 
-	user.CreatedAt = time.Now().Unix()
-	user.UpdatedAt = time.Now().Unix()
+	user.CreatedAt = b.now().Unix()
+	user.UpdatedAt = b.now().Unix()
 
 	b.users.Lock()
 	defer b.users.Unlock()
@@ -109,10 +218,17 @@ func (b *bank) CreateAccount(ctx context.Context, user proto.User) (*proto.User,
 		return nil, ErrAccountExist
 	}
 	b.users.data[user.Account] = user
+	metrics.Accounts.Inc()
+	slog.InfoContext(ctx, "account created", "account", user.Account)
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditAccountCreated,
+		Account: user.Account,
+		IP:      clientIP(ctx),
+	})
 	return &user, nil
 }
 
-func (b *bank) Deposit(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+func (b *bank) deposit(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
 
 	if !utils.IsEmpty(tx.From) {
 		return nil, "", ErrFromAccount
@@ -130,17 +246,16 @@ func (b *bank) Deposit(ctx context.Context, tx proto.Transaction, nonce string)
 		return nil, "", ErrEmptyNonce
 	}
 
-	b.users.RLock()
+	// the account is read under the write lock, a concurrent request with
+	// the same nonce finds it rotated
+	b.lockWrite(metrics.ActionDeposit)
+	defer b.users.Unlock()
+	defer b.txs.Unlock()
 	user, ok := b.users.data[tx.To]
-	b.users.RUnlock()
 	if !ok || user.Nonce != nonce {
 		return nil, "", ErrVerify
 	}
 
-	b.users.Lock()
-	b.txs.Lock()
-	defer b.users.Unlock()
-	defer b.txs.Unlock()
 	newNonce, err := utils.GenerateNonce(NonceLen)
 	if err != nil {
 		return nil, "", err
@@ -148,21 +263,29 @@ func (b *bank) Deposit(ctx context.Context, tx proto.Transaction, nonce string)
 
 	user.Balance += tx.Amount
 	user.Nonce = newNonce
-	user.UpdatedAt = time.Now().Unix()
+	user.UpdatedAt = b.now().Unix()
 
 	tx.ID = b.count
-	tx.CreatedAt = time.Now().Unix()
+	tx.CreatedAt = b.now().Unix()
 	tx.State = proto.TransactionStateSuccess
+	entries, err := b.journal(ctx, proto.AuditDeposit, user.Account, tx,
+		transactionEvent(user.Account, user.Balance, tx))
+	if err != nil {
+		return nil, "", err
+	}
 
 	b.users.data[user.Account] = user
 	b.txs.data[tx.ID] = tx
 	atomic.AddUint64(&b.count, 1)
 	b.search.Add(user.Account, tx.ID)
+	b.outbox.commit(entries)
+	slog.InfoContext(ctx, "deposit", "account", user.Account, "transaction", tx.ID, "amount", tx.Amount)
+	b.recordNonceRotation(ctx, proto.AuditDeposit, user.Account)
 
 	return &tx, newNonce, nil
 }
 
-func (b *bank) Withdraw(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+func (b *bank) withdraw(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
 
 	if utils.IsEmpty(tx.From) {
 		return nil, "", ErrFromAccount
@@ -180,10 +303,11 @@ func (b *bank) Withdraw(ctx context.Context, tx proto.Transaction, nonce string)
 		return nil, "", ErrEmptyNonce
 	}
 
-	b.users.RLock()
+	// the balance is checked under the lock of its change
+	b.lockWrite(metrics.ActionWithdraw)
+	defer b.users.Unlock()
+	defer b.txs.Unlock()
 	user, ok := b.users.data[tx.From]
-	b.users.RUnlock()
-
 	if !ok || user.Nonce != nonce {
 		return nil, "", ErrVerify
 	}
@@ -192,31 +316,35 @@ func (b *bank) Withdraw(ctx context.Context, tx proto.Transaction, nonce string)
 		return nil, "", ErrBalanceNotEnough
 	}
 
-	b.users.Lock()
-	b.txs.Lock()
-	defer b.users.Unlock()
-	defer b.txs.Unlock()
 	newNonce, err := utils.GenerateNonce(NonceLen)
 	if err != nil {
 		return nil, "", err
 	}
 	user.Balance -= tx.Amount
 	user.Nonce = newNonce
-	user.UpdatedAt = time.Now().Unix()
+	user.UpdatedAt = b.now().Unix()
 
 	tx.ID = b.count
-	tx.CreatedAt = time.Now().Unix()
+	tx.CreatedAt = b.now().Unix()
 	tx.State = proto.TransactionStateSuccess
+	entries, err := b.journal(ctx, proto.AuditWithdraw, user.Account, tx,
+		transactionEvent(user.Account, user.Balance, tx))
+	if err != nil {
+		return nil, "", err
+	}
 
 	b.users.data[user.Account] = user
 	b.txs.data[tx.ID] = tx
 	atomic.AddUint64(&b.count, 1)
 	b.search.Add(user.Account, tx.ID)
+	b.outbox.commit(entries)
+	slog.InfoContext(ctx, "withdraw", "account", user.Account, "transaction", tx.ID, "amount", tx.Amount)
+	b.recordNonceRotation(ctx, proto.AuditWithdraw, user.Account)
 
 	return &tx, newNonce, nil
 }
 
-func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+func (b *bank) transfer(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
 
 	if utils.IsEmpty(tx.From) {
 		return nil, "", ErrFromAccount
@@ -226,6 +354,10 @@ func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce stri
 		return nil, "", ErrToAccount
 	}
 
+	if tx.From == tx.To {
+		return nil, "", ErrSameAccount
+	}
+
 	if tx.Amount <= 0 {
 		return nil, "", ErrNegativeBalance
 	}
@@ -234,11 +366,11 @@ func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce stri
 		return nil, "", ErrEmptyNonce
 	}
 
-	b.users.RLock()
+	b.lockWrite(metrics.ActionTransfer)
+	defer b.users.Unlock()
+	defer b.txs.Unlock()
 	fromUser, ok := b.users.data[tx.From]
 	toUser, ok2 := b.users.data[tx.To]
-	b.users.RUnlock()
-
 	if !ok || fromUser.Nonce != nonce || !ok2 {
 		return nil, "", ErrVerify
 	}
@@ -247,25 +379,27 @@ func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce stri
 		return nil, "", ErrBalanceNotEnough
 	}
 
-	b.users.Lock()
-	b.txs.Lock()
-	defer b.users.Unlock()
-	defer b.txs.Unlock()
 	newNonce, err := utils.GenerateNonce(NonceLen)
 	if err != nil {
 		return nil, "", err
 	}
 
 	toUser.Balance += tx.Amount
-	toUser.UpdatedAt = time.Now().Unix()
+	toUser.UpdatedAt = b.now().Unix()
 
 	fromUser.Balance -= tx.Amount
 	fromUser.Nonce = newNonce
-	fromUser.UpdatedAt = time.Now().Unix()
+	fromUser.UpdatedAt = b.now().Unix()
 
 	tx.ID = b.count
-	tx.CreatedAt = time.Now().Unix()
+	tx.CreatedAt = b.now().Unix()
 	tx.State = proto.TransactionStateSuccess
+	entries, err := b.journal(ctx, proto.AuditTransfer, fromUser.Account, tx,
+		transactionEvent(fromUser.Account, fromUser.Balance, tx),
+		transactionEvent(toUser.Account, toUser.Balance, tx))
+	if err != nil {
+		return nil, "", err
+	}
 
 	b.users.data[fromUser.Account] = fromUser
 	b.users.data[toUser.Account] = toUser
@@ -273,11 +407,14 @@ func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce stri
 	atomic.AddUint64(&b.count, 1)
 	b.search.Add(fromUser.Account, tx.ID)
 	b.search.Add(toUser.Account, tx.ID)
+	b.outbox.commit(entries)
+	slog.InfoContext(ctx, "transfer", "from", tx.From, "to", tx.To, "transaction", tx.ID, "amount", tx.Amount)
+	b.recordNonceRotation(ctx, proto.AuditTransfer, fromUser.Account)
 
 	return &tx, newNonce, nil
 }
 
-func (b *bank) GetNonce(ctx context.Context, account string, pwd string) (string, error) {
+func (b *bank) GetNonce(ctx context.Context, account, pwd, code string) (string, error) {
 	if utils.IsEmpty(account) {
 		return "", ErrEmptyAccount
 	}
@@ -286,14 +423,28 @@ func (b *bank) GetNonce(ctx context.Context, account string, pwd string) (string
 		return "", ErrEmptyPwd
 	}
 
+	ip := clientIP(ctx)
+	if err := b.checkGuard(ctx, account, ip); err != nil {
+		return "", err
+	}
+
 	b.users.RLock()
 	user, ok := b.users.data[account]
 	b.users.RUnlock()
 
 	if !ok || user.Password != pwd {
+		b.guard.Fail(ctx, account, ip, b.now())
 		return "", ErrVerify
 	}
 
+	if err := b.verifySecondFactor(account, code); err != nil {
+		if errors.Is(err, ErrTOTPInvalid) {
+			b.guard.Fail(ctx, account, ip, b.now())
+		}
+		return "", err
+	}
+	b.guard.Succeed(account)
+
 	b.users.Lock()
 	defer b.users.Unlock()
 
@@ -303,9 +454,20 @@ func (b *bank) GetNonce(ctx context.Context, account string, pwd string) (string
 	}
 
 	user.Nonce = nonce
-	user.UpdatedAt = time.Now().Unix()
+	user.UpdatedAt = b.now().Unix()
 
 	b.users.data[account] = user
+	slog.InfoContext(ctx, "token issued", "account", account, "ip", ip)
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditLoginSucceeded,
+		Account: account,
+		IP:      ip,
+	})
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditNonceRotated,
+		Account: account,
+		Detail:  proto.AuditLoginSucceeded,
+	})
 
 	return nonce, nil
 }
@@ -352,3 +514,37 @@ func (b *bank) GetTransactions(ctx context.Context, account string) ([]proto.Tra
 
 	return resp, nil
 }
+
+// GetTransaction returns the transaction only to its parties, it is not
+// found for anyone else
+func (b *bank) GetTransaction(ctx context.Context, account string, id uint64) (*proto.Transaction, error) {
+	if utils.IsEmpty(account) {
+		return nil, ErrEmptyAccount
+	}
+
+	b.txs.RLock()
+	tx, ok := b.txs.data[id]
+	b.txs.RUnlock()
+
+	if !ok || (tx.From != account && tx.To != account) {
+		return nil, ErrTxNotFound
+	}
+	return &tx, nil
+}
+
+func (b *bank) UnlockAccount(ctx context.Context, account string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+
+	b.users.RLock()
+	_, ok := b.users.data[account]
+	b.users.RUnlock()
+
+	if !ok {
+		return ErrAccountNotExist
+	}
+
+	b.guard.Release(ctx, account, b.now())
+	return nil
+}
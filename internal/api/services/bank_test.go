@@ -5,13 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
 	"github.com/0x726f6f6b6965/bank/internal/proto"
 	"github.com/google/uuid"
 )
 
-var ctx context.Context
+var (
+	ctx context.Context
+	// start - the time the fake clocks of the tests start at
+	start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
 
 func TestMain(m *testing.M) {
 	setup()
@@ -30,7 +40,10 @@ func teardown() {
 	fmt.Printf("\n")
 }
 func TestGetNonce(t *testing.T) {
+	clk := clock.NewFake(start)
 	service := &bank{
+		clock: clk,
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -48,20 +61,27 @@ func TestGetNonce(t *testing.T) {
 	}
 	service.users.Unlock()
 
-	nonce, err := service.GetNonce(ctx, "test", "test-pwd")
+	clk.Advance(time.Minute)
+	nonce, err := service.GetNonce(ctx, "test", "test-pwd", "")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 
 	service.users.RLock()
 	defer service.users.RUnlock()
-	if nonce != service.users.data["test"].Nonce {
-		t.Fatalf("Expected nonce: %v, got: %v", service.users.data["test"].Nonce, nonce)
+	user := service.users.data["test"]
+	if nonce != user.Nonce {
+		t.Fatalf("Expected nonce: %v, got: %v", user.Nonce, nonce)
+	}
+	if at := start.Add(time.Minute).Unix(); user.UpdatedAt != at {
+		t.Fatalf("Expected updated at: %v, got: %v", at, user.UpdatedAt)
 	}
 }
 
 func TestGetNonceWithError(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -70,7 +90,7 @@ func TestGetNonceWithError(t *testing.T) {
 		},
 	}
 
-	_, err := service.GetNonce(ctx, "", "")
+	_, err := service.GetNonce(ctx, "", "", "")
 	if !errors.Is(err, ErrEmptyAccount) {
 		t.Fatalf("Expected error: %v, got: %v", ErrEmptyAccount, err)
 	}
@@ -84,12 +104,12 @@ func TestGetNonceWithError(t *testing.T) {
 	}
 	service.users.Unlock()
 
-	_, err = service.GetNonce(ctx, "test", "")
+	_, err = service.GetNonce(ctx, "test", "", "")
 	if !errors.Is(err, ErrEmptyPwd) {
 		t.Fatalf("Expected error: %v, got: %v", ErrEmptyPwd, err)
 	}
 
-	_, err = service.GetNonce(ctx, "test", "t")
+	_, err = service.GetNonce(ctx, "test", "t", "")
 	if !errors.Is(err, ErrVerify) {
 		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
 	}
@@ -97,6 +117,8 @@ func TestGetNonceWithError(t *testing.T) {
 
 func TestCreateAccount(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -104,8 +126,9 @@ func TestCreateAccount(t *testing.T) {
 			data: make(map[uint64]proto.Transaction),
 		},
 	}
+	gen := ids.NewSequence()
 	user := proto.User{
-		Account:  uuid.NewString(),
+		Account:  gen.NewID(),
 		Name:     "test",
 		Balance:  100,
 		Password: "XXXXX",
@@ -118,6 +141,12 @@ func TestCreateAccount(t *testing.T) {
 	if info.Nonce == "" {
 		t.Fatalf("Expected nonce: not empty, got: %v", info.Nonce)
 	}
+	if info.Account != "00000000-0000-4000-8000-000000000001" {
+		t.Fatalf("Expected account: %v, got: %v", "00000000-0000-4000-8000-000000000001", info.Account)
+	}
+	if info.CreatedAt != start.Unix() || info.UpdatedAt != start.Unix() {
+		t.Fatalf("Expected created and updated at: %v, got: %v and %v", start.Unix(), info.CreatedAt, info.UpdatedAt)
+	}
 
 	_, err = service.CreateAccount(ctx, user)
 	if !errors.Is(err, ErrAccountExist) {
@@ -127,6 +156,8 @@ func TestCreateAccount(t *testing.T) {
 
 func TestCreateAccountWithError(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -154,8 +185,51 @@ func TestCreateAccountWithError(t *testing.T) {
 	}
 }
 
+func TestCreateAccountWithPolicy(t *testing.T) {
+	p, err := policy.New(config.PolicyConfig{RequireDigit: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		policy: p,
+	}
+	user := proto.User{
+		Account:  ids.NewSequence().NewID(),
+		Name:     "<test>",
+		Balance:  -1,
+		Password: "short",
+	}
+
+	_, err = service.CreateAccount(ctx, user)
+	var verr *policy.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected validation error, got: %v", err)
+	}
+	fields := map[string]int{}
+	for _, f := range verr.Fields {
+		fields[f.Field]++
+	}
+	if fields["password"] != 2 || fields["name"] != 1 || fields["balance"] != 1 {
+		t.Fatalf("Unexpected fields: %v", verr.Fields)
+	}
+	if !errors.Is(err, ErrNegativeBalance) || !errors.Is(err, policy.ErrPasswordTooShort) {
+		t.Fatalf("Expected errors: %v and %v, got: %v", ErrNegativeBalance, policy.ErrPasswordTooShort, err)
+	}
+}
+
 func TestDeposit(t *testing.T) {
+	clk := clock.NewFake(start)
 	service := &bank{
+		clock: clk,
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -181,6 +255,7 @@ func TestDeposit(t *testing.T) {
 	}
 	service.users.Unlock()
 
+	clk.Advance(time.Minute)
 	txLog, newNonce, err := service.Deposit(ctx, tx, "test-nonce")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
@@ -188,13 +263,18 @@ func TestDeposit(t *testing.T) {
 	if newNonce == "" || newNonce == "test-nonce" {
 		t.Fatalf("Expected nonce: not empty, got: %v", newNonce)
 	}
-	if txLog.ID == 0 {
-		t.Fatalf("Expected tx log: not empty, got: %v", txLog.ID)
+	if txLog.ID != 1 || txLog.CreatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected tx log: %v at: %v, got: %+v", 1, start.Add(time.Minute).Unix(), txLog)
+	}
+	if user := service.users.data["test"]; user.UpdatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected updated at: %v, got: %v", start.Add(time.Minute).Unix(), user.UpdatedAt)
 	}
 }
 
 func TestDepositWithError(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -250,7 +330,10 @@ func TestDepositWithError(t *testing.T) {
 }
 
 func TestWithdraw(t *testing.T) {
+	clk := clock.NewFake(start)
 	service := &bank{
+		clock: clk,
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -276,6 +359,7 @@ func TestWithdraw(t *testing.T) {
 	}
 	service.users.Unlock()
 
+	clk.Advance(time.Minute)
 	txLog, newNonce, err := service.Withdraw(ctx, tx, "test-nonce")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
@@ -283,13 +367,18 @@ func TestWithdraw(t *testing.T) {
 	if newNonce == "" || newNonce == "test-nonce" {
 		t.Fatalf("Expected nonce: not empty, got: %v", newNonce)
 	}
-	if txLog.ID == 0 {
-		t.Fatalf("Expected tx log: not empty, got: %v", txLog.ID)
+	if txLog.ID != 1 || txLog.CreatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected tx log: %v at: %v, got: %+v", 1, start.Add(time.Minute).Unix(), txLog)
+	}
+	if user := service.users.data["test"]; user.UpdatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected updated at: %v, got: %v", start.Add(time.Minute).Unix(), user.UpdatedAt)
 	}
 }
 
 func TestWithdrawWithError(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -350,7 +439,10 @@ func TestWithdrawWithError(t *testing.T) {
 }
 
 func TestTransaction(t *testing.T) {
+	clk := clock.NewFake(start)
 	service := &bank{
+		clock: clk,
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -385,6 +477,7 @@ func TestTransaction(t *testing.T) {
 	}
 	service.users.Unlock()
 
+	clk.Advance(time.Minute)
 	txLog, newNonce, err := service.Transaction(ctx, tx, "test-nonce")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
@@ -392,13 +485,21 @@ func TestTransaction(t *testing.T) {
 	if newNonce == "" || newNonce == "test-nonce" {
 		t.Fatalf("Expected nonce: not empty, got: %v", newNonce)
 	}
-	if txLog.ID == 0 {
-		t.Fatalf("Expected tx log: not empty, got: %v", txLog.ID)
+	if txLog.ID != 1 || txLog.CreatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected tx log: %v at: %v, got: %+v", 1, start.Add(time.Minute).Unix(), txLog)
+	}
+	if user := service.users.data["test"]; user.UpdatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected updated at: %v, got: %v", start.Add(time.Minute).Unix(), user.UpdatedAt)
+	}
+	if user := service.users.data["test2"]; user.UpdatedAt != start.Add(time.Minute).Unix() {
+		t.Fatalf("Expected updated at: %v, got: %v", start.Add(time.Minute).Unix(), user.UpdatedAt)
 	}
 }
 
 func TestTransactionWithError(t *testing.T) {
 	service := &bank{
+		clock: clock.NewFake(start),
+		ids:   ids.NewSequence(),
 		users: &userMap{
 			data: make(map[string]proto.User),
 		},
@@ -425,6 +526,12 @@ func TestTransactionWithError(t *testing.T) {
 		t.Fatalf("Expected error: %v, got: %v", ErrToAccount, err)
 	}
 
+	tx.To = "test"
+	_, _, err = service.Transaction(ctx, tx, "")
+	if !errors.Is(err, ErrSameAccount) {
+		t.Fatalf("Expected error: %v, got: %v", ErrSameAccount, err)
+	}
+
 	tx.To = "test2"
 	_, _, err = service.Transaction(ctx, tx, "")
 	if !errors.Is(err, ErrNegativeBalance) {
@@ -466,6 +573,49 @@ func TestTransactionWithError(t *testing.T) {
 	}
 }
 
+func TestTransactionConcurrent(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+
+	// the requests share the nonce, only the first one moves money
+	errs := make(chan error, 50)
+	ready := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ready
+			_, _, err := service.Transaction(ctx, proto.Transaction{From: "test", To: "test2", Amount: 60}, "test-nonce")
+			errs <- err
+		}()
+	}
+	close(ready)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrVerify):
+			t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("Expected successful transactions: %v, got: %v", 1, succeeded)
+	}
+	if balance := service.users.data["test"].Balance; balance != 40 {
+		t.Fatalf("Expected balance: %v, got: %v", 40, balance)
+	}
+	if balance := service.users.data["test2"].Balance; balance != 70 {
+		t.Fatalf("Expected balance: %v, got: %v", 70, balance)
+	}
+	if n := len(service.txs.data); n != 1 {
+		t.Fatalf("Expected transactions: %v, got: %v", 1, n)
+	}
+}
+
 func TestGetBalance(t *testing.T) {
 	service := &bank{
 		users: &userMap{
@@ -544,7 +694,7 @@ func TestGetTransactions(t *testing.T) {
 		Nonce:    "test2-nonce",
 	}
 	service.users.Unlock()
-	nonce, err := service.GetNonce(ctx, "test", pwd)
+	nonce, err := service.GetNonce(ctx, "test", pwd, "")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -600,3 +750,58 @@ func TestGetTransactionsWithError(t *testing.T) {
 		t.Fatalf("Expected error: %v, got: %v", ErrAccountNotExist, err)
 	}
 }
+
+func TestGetTransaction(t *testing.T) {
+	service := &bank{
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		count:  1,
+		search: NewSearch(),
+	}
+	service.users.data["test"] = proto.User{
+		Account: "test",
+		Balance: 100,
+		Nonce:   "test-nonce",
+	}
+	service.users.data["test2"] = proto.User{
+		Account: "test2",
+		Balance: 100,
+		Nonce:   "test2-nonce",
+	}
+	tx, _, err := service.Transaction(ctx, proto.Transaction{
+		From:   "test",
+		To:     "test2",
+		Amount: 30,
+	}, "test-nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, account := range []string{"test", "test2"} {
+		got, err := service.GetTransaction(ctx, account, tx.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got.Amount != 30 || got.State != proto.TransactionStateSuccess || got.CreatedAt == 0 {
+			t.Fatalf("Expected transaction: %v, got: %v", tx, got)
+		}
+	}
+
+	// not a party
+	_, err = service.GetTransaction(ctx, "test3", tx.ID)
+	if !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTxNotFound, err)
+	}
+	_, err = service.GetTransaction(ctx, "test", tx.ID+1)
+	if !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTxNotFound, err)
+	}
+	_, err = service.GetTransaction(ctx, "", tx.ID)
+	if !errors.Is(err, ErrEmptyAccount) {
+		t.Fatalf("Expected error: %v, got: %v", ErrEmptyAccount, err)
+	}
+}
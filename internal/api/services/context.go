@@ -0,0 +1,28 @@
+package services
+
+import "context"
+
+type (
+	clientIPKey  struct{}
+	userAgentKey struct{}
+)
+
+// WithClientIP returns a copy of ctx carrying the IP of the caller
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// WithUserAgent returns a copy of ctx carrying the user agent of the caller
+func WithUserAgent(ctx context.Context, ua string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, ua)
+}
+
+func userAgent(ctx context.Context) string {
+	ua, _ := ctx.Value(userAgentKey{}).(string)
+	return ua
+}
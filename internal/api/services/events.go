@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+var (
+	DefaultEventHistory = 100
+	DefaultEventBuffer  = 64
+)
+
+// eventBus fans the events of an account out to its subscribers, it keeps
+// the latest events of every account so a subscriber can resume
+type eventBus struct {
+	sync.Mutex
+	history int
+	buffer  int
+	// events - account -> latest events, the oldest first
+	events map[string][]proto.Event
+	// subs - account -> subscribers
+	subs map[string]map[*subscriber]struct{}
+	// closed - the streams ended for a shutdown
+	closed bool
+}
+
+type subscriber struct {
+	ch chan proto.Event
+}
+
+func newEventBus(cfg config.EventsConfig) *eventBus {
+	if cfg.History <= 0 {
+		cfg.History = DefaultEventHistory
+	}
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = DefaultEventBuffer
+	}
+	return &eventBus{
+		history: cfg.History,
+		buffer:  cfg.Buffer,
+		events:  make(map[string][]proto.Event),
+		subs:    make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// Publish sends the event of an outbox message to the stream of its
+// account, the event keeps the ID of the message
+func (e *eventBus) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	e.send(msg.Event)
+	return nil
+}
+
+// send delivers the event without blocking, a subscriber which fell a
+// whole buffer behind is dropped and has to resume
+func (e *eventBus) send(event proto.Event) {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+
+	events := append(e.events[event.Account], event)
+	if len(events) > e.history {
+		events = events[len(events)-e.history:]
+	}
+	e.events[event.Account] = events
+
+	for s := range e.subs[event.Account] {
+		select {
+		case s.ch <- event:
+		default:
+			e.drop(event.Account, s)
+		}
+	}
+}
+
+// Subscribe returns the events of the account after the event ID after,
+// the kept ones first, the channel is closed once ctx is done
+func (e *eventBus) Subscribe(ctx context.Context, account string, after uint64) <-chan proto.Event {
+	e.Lock()
+	var missed []proto.Event
+	for _, event := range e.events[account] {
+		if event.ID > after {
+			missed = append(missed, event)
+		}
+	}
+	s := &subscriber{ch: make(chan proto.Event, len(missed)+e.buffer)}
+	for _, event := range missed {
+		s.ch <- event
+	}
+	if e.closed {
+		e.Unlock()
+		close(s.ch)
+		return s.ch
+	}
+	if e.subs[account] == nil {
+		e.subs[account] = make(map[*subscriber]struct{})
+	}
+	e.subs[account][s] = struct{}{}
+	e.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		e.Lock()
+		defer e.Unlock()
+		e.drop(account, s)
+	}()
+	return s.ch
+}
+
+// Close ends the streams of every subscriber, the later subscribers only
+// get the kept events
+func (e *eventBus) Close() {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.closed = true
+	for account, subs := range e.subs {
+		for s := range subs {
+			e.drop(account, s)
+		}
+	}
+}
+
+// drop closes the channel of the subscriber once, the caller holds the lock
+func (e *eventBus) drop(account string, s *subscriber) {
+	subs, ok := e.subs[account]
+	if !ok {
+		return
+	}
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(e.subs, account)
+	}
+	close(s.ch)
+}
+
+// transactionEvent returns the event of a transaction for an account with
+// its new balance
+func transactionEvent(account string, balance int, tx proto.Transaction) proto.Event {
+	eventType := proto.EventDebited
+	if tx.To == account {
+		eventType = proto.EventCredited
+	}
+	return proto.Event{
+		Type:        eventType,
+		Account:     account,
+		Balance:     balance,
+		Transaction: tx,
+		CreatedAt:   tx.CreatedAt,
+	}
+}
+
+// journal writes the outbox messages of the events of a transaction and
+// then its audit entry, the caller holds the lock of the transactions. The
+// transaction is applied only without error and its messages reach the
+// stream and the webhooks once they are committed with it.
+func (b *bank) journal(ctx context.Context, eventType, account string, tx proto.Transaction, events ...proto.Event) ([]*outboxEntry, error) {
+	entries, err := b.outbox.prepare(events, b.now())
+	if err != nil {
+		slog.ErrorContext(ctx, "outbox write failed", "transaction", tx.ID, "error", err)
+		return nil, ErrStorageDown
+	}
+	if err := b.recordTransaction(ctx, eventType, account, tx); err != nil {
+		b.outbox.abort(entries)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (b *bank) Subscribe(ctx context.Context, account string, after uint64) (<-chan proto.Event, error) {
+	if utils.IsEmpty(account) {
+		return nil, ErrEmptyAccount
+	}
+	if b.events == nil {
+		return nil, ErrEventsDisabled
+	}
+	if b.draining.Load() {
+		return nil, ErrShuttingDown
+	}
+
+	b.users.RLock()
+	_, ok := b.users.data[account]
+	b.users.RUnlock()
+	if !ok {
+		return nil, ErrAccountNotExist
+	}
+	return b.events.Subscribe(ctx, account, after), nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus(config.EventsConfig{History: 2, Buffer: 1})
+	var id uint64
+	publish := func(account string) {
+		id++
+		msg := proto.OutboxMessage{ID: id, Account: account, Event: proto.Event{ID: id, Type: proto.EventCredited, Account: account}}
+		if err := bus.Publish(ctx, msg); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		publish("test")
+	}
+
+	// only the latest events are kept
+	sctx, cancel := context.WithCancel(ctx)
+	events := bus.Subscribe(sctx, "test", 0)
+	if event := <-events; event.ID != 2 {
+		t.Fatalf("Expected event: %v, got: %v", 2, event.ID)
+	}
+	if event := <-events; event.ID != 3 {
+		t.Fatalf("Expected event: %v, got: %v", 3, event.ID)
+	}
+
+	publish("test2")
+	publish("test")
+	if event := <-events; event.ID != 5 || event.Account != "test" {
+		t.Fatalf("Expected event: %v, got: %v", 5, event)
+	}
+
+	// the stream ends with the context
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatalf("Expected a closed stream")
+	}
+
+	// resume after an event
+	events = bus.Subscribe(ctx, "test", 3)
+	if event := <-events; event.ID != 5 {
+		t.Fatalf("Expected event: %v, got: %v", 5, event.ID)
+	}
+
+	// a subscriber a whole buffer behind is dropped, the resumed stream
+	// holds the missed event and the buffer
+	for i := 0; i < 3; i++ {
+		publish("test")
+	}
+	for _, id := range []uint64{6, 7} {
+		if event := <-events; event.ID != id {
+			t.Fatalf("Expected event: %v, got: %v", id, event.ID)
+		}
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("Expected a closed stream")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+
+	_, err := service.Subscribe(ctx, "t", 0)
+	if !errors.Is(err, ErrAccountNotExist) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountNotExist, err)
+	}
+	events, err := service.Subscribe(ctx, "test2", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the event reaches the stream through the outbox, with the ID of its
+	// message
+	tx, _, err := service.Transaction(ctx, proto.Transaction{From: "test", To: "test2", Amount: 30}, "test-nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no event before the relay, got: %v", event)
+	default:
+	}
+	service.relay.drain()
+	event := <-events
+	if event.Balance != 40 || event.Transaction.ID != tx.ID || event.Type != proto.EventCredited || event.ID != 2 {
+		t.Fatalf("Expected event: %v with balance: %v of transaction: %v, got: %v", 2, 40, tx.ID, event)
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Ready reports whether the bank can take requests, it fails once the
+// shutdown started or while the outbox or the audit log can not be
+// written. The accounts and the transactions are kept in memory and are
+// always available.
+func (b *bank) Ready(ctx context.Context) error {
+	if b.draining.Load() {
+		return ErrShuttingDown
+	}
+	if err := b.outbox.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrStorageDown, err)
+	}
+	if a, ok := b.auditor.(interface{ Err() error }); ok {
+		if err := a.Err(); err != nil {
+			return fmt.Errorf("%w: %s", ErrStorageDown, err)
+		}
+	}
+	return nil
+}
+
+// Drain starts the shutdown, the readiness fails and the event streams end
+// while the requests in flight still complete
+func (b *bank) Drain(ctx context.Context) {
+	if b.draining.Swap(true) {
+		return
+	}
+	b.events.Close()
+	slog.InfoContext(ctx, "bank draining")
+}
+
+// Close drains the bank, publishes the due outbox messages and keeps the
+// others in its store, stops the webhook retries and flushes the audit log,
+// the requests must have finished. It returns the error of ctx when it ends
+// first.
+func (b *bank) Close(ctx context.Context) error {
+	b.Drain(ctx)
+	done := make(chan error, 1)
+	go func() {
+		err := b.relay.Close()
+		err = errors.Join(err, b.outbox.Close())
+		b.webhooks.Close()
+		if c, ok := b.auditor.(io.Closer); ok {
+			err = errors.Join(err, c.Close())
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
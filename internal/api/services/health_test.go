@@ -0,0 +1,120 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/audit"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestReady(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.auditor = NewChainAuditor(audit.NewChain(failingWriter{}, 0, audit.Genesis))
+	if err := service.Ready(ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// a failed append of the audit log refuses the deposit and makes the
+	// bank unready
+	_, _, err := service.Deposit(ctx, proto.Transaction{To: "test", Amount: 10}, "test-nonce")
+	if !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+	if err := service.Ready(ctx); !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+}
+
+func TestReadyOutbox(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := openOutbox(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer o.Close()
+	service.outbox = o
+	if err := service.Ready(ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// a failed write of the outbox refuses the deposit and makes the bank
+	// unready
+	f := o.store.f
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	o.store.f = readOnly
+	_, _, err = service.Deposit(ctx, proto.Transaction{To: "test", Amount: 10}, "test-nonce")
+	if !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+	if err := service.Ready(ctx); !errors.Is(err, ErrStorageDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrStorageDown, err)
+	}
+
+	// the bank is ready again once a write succeeds
+	o.store.f = f
+	if _, _, err := service.Deposit(ctx, proto.Transaction{To: "test", Amount: 10}, "test-nonce"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.Ready(ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	buf := &bytes.Buffer{}
+	chain := audit.NewChain(buf, 0, audit.Genesis)
+	publisher := &memoryPublisher{}
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.auditor = NewChainAuditor(chain)
+	service.relay = newRelay(config.OutboxConfig{Interval: time.Hour}, service.outbox, nil, publisher)
+	service.relay.Start()
+	events, err := service.Subscribe(ctx, "test", 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	service.Drain(ctx)
+	if err := service.Ready(ctx); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrShuttingDown, err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatalf("Expected the event stream to end")
+	}
+	if _, err := service.Subscribe(ctx, "test", 0); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("Expected error: %v, got: %v", ErrShuttingDown, err)
+	}
+
+	// the requests in flight still complete while draining
+	if _, _, err := service.Deposit(ctx, proto.Transaction{To: "test", Amount: 10}, "test-nonce"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := service.Close(ctx); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if n := len(publisher.published()); n != 1 {
+		t.Fatalf("Expected published messages: %d, got: %d", 1, n)
+	}
+	if _, err := chain.Append(proto.AuditEvent{}); !errors.Is(err, audit.ErrClosed) {
+		t.Fatalf("Expected error: %v, got: %v", audit.ErrClosed, err)
+	}
+	if _, n, err := audit.Verify(bytes.NewReader(buf.Bytes())); err != nil || n != 2 {
+		t.Fatalf("Expected 2 valid entries, got: %d, %v", n, err)
+	}
+}
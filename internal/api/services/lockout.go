@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+var (
+	DefaultMaxAttempts   = 5
+	DefaultIPMaxAttempts = 20
+	DefaultBaseDelay     = time.Second
+	DefaultMaxDelay      = time.Minute
+	DefaultLockDuration  = 15 * time.Minute
+)
+
+// attempt - failed login series of an account or an IP
+type attempt struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	lockedUntil  time.Time
+}
+
+// loginGuard tracks failed logins per account and per IP, it backs off
+// exponentially after every failure and locks the key once the
+// configured number of failures is reached.
+type loginGuard struct {
+	sync.Mutex
+	cfg       config.LockoutConfig
+	accounts  map[string]*attempt
+	ips       map[string]*attempt
+	auditor   Auditor
+	lastPrune time.Time
+}
+
+func newLoginGuard(cfg config.LockoutConfig, auditor Auditor) *loginGuard {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.IPMaxAttempts <= 0 {
+		cfg.IPMaxAttempts = DefaultIPMaxAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultBaseDelay
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = max(DefaultMaxDelay, cfg.BaseDelay)
+	}
+	if cfg.LockDuration <= 0 {
+		cfg.LockDuration = DefaultLockDuration
+	}
+	return &loginGuard{
+		cfg:      cfg,
+		accounts: make(map[string]*attempt),
+		ips:      make(map[string]*attempt),
+		auditor:  auditor,
+	}
+}
+
+// Check returns an error when the account or the IP is not allowed to try now
+func (g *loginGuard) Check(account, ip string, now time.Time) error {
+	if g == nil {
+		return nil
+	}
+	g.Lock()
+	defer g.Unlock()
+
+	if a, ok := g.accounts[account]; ok {
+		if now.Before(a.lockedUntil) {
+			return ErrAccountLocked
+		}
+		if now.Before(a.blockedUntil) {
+			return ErrTooManyAttempts
+		}
+	}
+	if a, ok := g.ips[ip]; ok && ip != "" {
+		if now.Before(a.lockedUntil) || now.Before(a.blockedUntil) {
+			return ErrTooManyAttempts
+		}
+	}
+	return nil
+}
+
+// Fail records a failed login of the account from the IP at now
+func (g *loginGuard) Fail(ctx context.Context, account, ip string, now time.Time) {
+	if g == nil {
+		return
+	}
+	events := []proto.AuditEvent{{
+		Type:      proto.AuditLoginFailed,
+		Account:   account,
+		IP:        ip,
+		CreatedAt: now.Unix(),
+	}}
+
+	g.Lock()
+	g.prune(now)
+	if g.fail(g.accounts, account, g.cfg.MaxAttempts, now) {
+		events = append(events, proto.AuditEvent{
+			Type:      proto.AuditAccountLocked,
+			Account:   account,
+			IP:        ip,
+			Detail:    fmt.Sprintf("locked for %s", g.cfg.LockDuration),
+			CreatedAt: now.Unix(),
+		})
+	}
+	if ip != "" && g.fail(g.ips, ip, g.cfg.IPMaxAttempts, now) {
+		events = append(events, proto.AuditEvent{
+			Type:      proto.AuditIPLocked,
+			IP:        ip,
+			Detail:    fmt.Sprintf("locked for %s", g.cfg.LockDuration),
+			CreatedAt: now.Unix(),
+		})
+	}
+	g.Unlock()
+
+	g.record(ctx, now, events...)
+}
+
+// Succeed clears the failed login series of the account
+func (g *loginGuard) Succeed(account string) {
+	if g == nil {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	delete(g.accounts, account)
+}
+
+// Release removes the lock of the account before it expires, it reports
+// whether the account was locked
+func (g *loginGuard) Release(ctx context.Context, account string, now time.Time) bool {
+	if g == nil {
+		return false
+	}
+	g.Lock()
+	a, ok := g.accounts[account]
+	locked := ok && now.Before(a.lockedUntil)
+	delete(g.accounts, account)
+	g.Unlock()
+
+	// the admin action is recorded even when there was nothing to unlock
+	detail := "unlocked by admin"
+	if !locked {
+		detail = "unlock by admin, the account was not locked"
+	}
+	g.record(ctx, now, proto.AuditEvent{
+		Type:      proto.AuditAccountUnlock,
+		Account:   account,
+		IP:        clientIP(ctx),
+		Detail:    detail,
+		CreatedAt: now.Unix(),
+	})
+	return locked
+}
+
+// fail increases the failures of the key, it reports whether the key got locked
+func (g *loginGuard) fail(m map[string]*attempt, key string, limit int, now time.Time) bool {
+	a, ok := m[key]
+	if !ok {
+		a = &attempt{}
+		m[key] = a
+	}
+	// an expired lock starts a new series
+	if !a.lockedUntil.IsZero() && !now.Before(a.lockedUntil) {
+		*a = attempt{}
+	}
+
+	a.failures++
+	a.lastFailure = now
+	a.blockedUntil = now.Add(g.backoff(a.failures))
+	if a.failures >= limit && a.lockedUntil.IsZero() {
+		a.lockedUntil = now.Add(g.cfg.LockDuration)
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay after the n-th failure
+func (g *loginGuard) backoff(n int) time.Duration {
+	delay := g.cfg.BaseDelay
+	for i := 1; i < n && delay < g.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.cfg.MaxDelay)
+}
+
+// prune drops the series which are neither blocked nor locked and had no
+// failure for a whole lock duration
+func (g *loginGuard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < time.Minute {
+		return
+	}
+	g.lastPrune = now
+	for _, m := range []map[string]*attempt{g.accounts, g.ips} {
+		for key, a := range m {
+			if now.After(a.lockedUntil) && now.After(a.blockedUntil) &&
+				now.Sub(a.lastFailure) > g.cfg.LockDuration {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+func (g *loginGuard) record(ctx context.Context, now time.Time, events ...proto.AuditEvent) {
+	if g.auditor == nil {
+		return
+	}
+	for _, event := range events {
+		stamp(ctx, &event, now)
+		g.auditor.Record(ctx, event)
+	}
+}
+
+// checkGuard asks the login guard whether the account may try from the IP,
+// a refused attempt is audited as it never reaches the password check
+func (b *bank) checkGuard(ctx context.Context, account, ip string) error {
+	err := b.guard.Check(account, ip, b.now())
+	if err != nil {
+		b.record(ctx, proto.AuditEvent{
+			Type:    proto.AuditLoginRefused,
+			Account: account,
+			IP:      ip,
+			Detail:  err.Error(),
+		})
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// newLockoutBank returns a bank with the account "test" whose clock stands
+// at start
+func newLockoutBank(cfg config.LockoutConfig) (*bank, *clock.Fake) {
+	clk := clock.NewFake(start)
+	service := &bank{
+		clock: clk,
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		guard: newLoginGuard(cfg, nil),
+	}
+	service.users.data["test"] = proto.User{
+		Account:  "test",
+		Balance:  100,
+		Password: "test-pwd",
+		Name:     "test-user",
+	}
+	return service, clk
+}
+
+func TestGetNonceBackoff(t *testing.T) {
+	service, clk := newLockoutBank(config.LockoutConfig{
+		MaxAttempts: 3,
+		BaseDelay:   time.Hour,
+	})
+
+	_, err := service.GetNonce(ctx, "test", "t", "")
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+
+	// even the right password is refused during the backoff
+	_, err = service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTooManyAttempts, err)
+	}
+
+	clk.Advance(time.Hour)
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestGetNonceLockout(t *testing.T) {
+	service, clk := newLockoutBank(config.LockoutConfig{
+		MaxAttempts:  3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Second,
+		LockDuration: time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		clk.Advance(time.Second)
+		_, err := service.GetNonce(ctx, "test", "t", "")
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+		}
+	}
+
+	_, err := service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+
+	clk.Advance(time.Hour - time.Second)
+	_, err = service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+
+	// the lock expires
+	clk.Advance(time.Second)
+
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestGetNonceIPLockout(t *testing.T) {
+	service, clk := newLockoutBank(config.LockoutConfig{
+		MaxAttempts:   10,
+		IPMaxAttempts: 2,
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Second,
+		LockDuration:  time.Hour,
+	})
+	ipCtx := WithClientIP(ctx, "10.0.0.1")
+
+	for _, account := range []string{"a", "b"} {
+		clk.Advance(time.Second)
+		_, err := service.GetNonce(ipCtx, account, "t", "")
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+		}
+	}
+
+	_, err := service.GetNonce(ipCtx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTooManyAttempts, err)
+	}
+
+	// other IPs are not affected
+	if _, err := service.GetNonce(WithClientIP(ctx, "10.0.0.2"), "test", "test-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	service, _ := newLockoutBank(config.LockoutConfig{
+		MaxAttempts:  1,
+		BaseDelay:    time.Hour,
+		LockDuration: time.Hour,
+	})
+
+	_, err := service.GetNonce(ctx, "test", "t", "")
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+	_, err = service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+
+	err = service.UnlockAccount(ctx, "t")
+	if !errors.Is(err, ErrAccountNotExist) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountNotExist, err)
+	}
+	if err := service.UnlockAccount(ctx, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// errorKinds label the failed transactions, the other errors are internal
+var errorKinds = []struct {
+	err  error
+	kind string
+}{
+	{ErrFromAccount, "invalid_from_account"},
+	{ErrToAccount, "invalid_to_account"},
+	{ErrSameAccount, "same_account"},
+	{ErrNegativeBalance, "negative_amount"},
+	{ErrEmptyNonce, "empty_nonce"},
+	{ErrVerify, "verify_failed"},
+	{ErrBalanceNotEnough, "insufficient_balance"},
+}
+
+func errorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return "internal"
+}
+
+func (b *bank) Deposit(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	result, newNonce, err := b.deposit(ctx, tx, nonce)
+	metrics.ObserveTransaction(metrics.ActionDeposit, tx.Amount, errorKind(err))
+	return result, newNonce, err
+}
+
+func (b *bank) Withdraw(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	result, newNonce, err := b.withdraw(ctx, tx, nonce)
+	metrics.ObserveTransaction(metrics.ActionWithdraw, tx.Amount, errorKind(err))
+	return result, newNonce, err
+}
+
+func (b *bank) Transaction(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	result, newNonce, err := b.transfer(ctx, tx, nonce)
+	metrics.ObserveTransaction(metrics.ActionTransfer, tx.Amount, errorKind(err))
+	return result, newNonce, err
+}
+
+// lockWrite takes the locks of the accounts and the transactions for a
+// write and observes the wait
+func (b *bank) lockWrite(operation string) {
+	start := time.Now()
+	b.users.Lock()
+	b.txs.Lock()
+	metrics.LockWait.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTransactionMetrics(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+	success := metrics.Transactions.WithLabelValues(metrics.ActionWithdraw, metrics.OutcomeSuccess, "")
+	insufficient := metrics.Transactions.WithLabelValues(metrics.ActionWithdraw, metrics.OutcomeFailure, "insufficient_balance")
+	verify := metrics.Transactions.WithLabelValues(metrics.ActionWithdraw, metrics.OutcomeFailure, "verify_failed")
+	before := []float64{testutil.ToFloat64(success), testutil.ToFloat64(insufficient), testutil.ToFloat64(verify)}
+
+	_, nonce, err := service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 10}, "test-nonce")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, _, err := service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 1000}, nonce); !errors.Is(err, ErrBalanceNotEnough) {
+		t.Fatalf("Expected error: %v, got: %v", ErrBalanceNotEnough, err)
+	}
+	if _, _, err := service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 10}, "wrong"); !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+
+	after := []float64{testutil.ToFloat64(success), testutil.ToFloat64(insufficient), testutil.ToFloat64(verify)}
+	for i := range before {
+		if after[i]-before[i] != 1 {
+			t.Fatalf("Expected counter %v to grow by %v, got: %v", i, 1, after[i]-before[i])
+		}
+	}
+	if n := testutil.CollectAndCount(metrics.LockWait); n == 0 {
+		t.Fatalf("Expected the lock waits to be observed")
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	if kind := errorKind(nil); kind != "" {
+		t.Fatalf("Expected kind: %q, got: %q", "", kind)
+	}
+	if kind := errorKind(ErrSameAccount); kind != "same_account" {
+		t.Fatalf("Expected kind: %v, got: %v", "same_account", kind)
+	}
+	if kind := errorKind(errors.New("boom")); kind != "internal" {
+		t.Fatalf("Expected kind: %v, got: %v", "internal", kind)
+	}
+}
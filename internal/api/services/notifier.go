@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// Notifier delivers messages to the users
+type Notifier interface {
+	Notify(ctx context.Context, msg proto.Notification) error
+}
+
+// NewNotifier returns the notifier of the configured type, it falls back
+// to the log notifier
+func NewNotifier(cfg config.NotifierConfig) Notifier {
+	switch cfg.Type {
+	case "file":
+		return &fileNotifier{path: cfg.Path}
+	default:
+		return &logNotifier{}
+	}
+}
+
+// logNotifier only logs that a message was sent, the body carries secrets
+// like the reset tokens so it never reaches the logs, the file notifier
+// keeps the bodies for local testing
+type logNotifier struct{}
+
+func (n *logNotifier) Notify(ctx context.Context, msg proto.Notification) error {
+	slog.InfoContext(ctx, "notify", "account", msg.Account, "subject", msg.Subject)
+	return nil
+}
+
+// fileNotifier appends every message as a JSON line to a file, it is
+// meant for local testing
+type fileNotifier struct {
+	sync.Mutex
+	path string
+}
+
+func (n *fileNotifier) Notify(ctx context.Context, msg proto.Notification) error {
+	n.Lock()
+	defer n.Unlock()
+	return appendJSONLine(n.path, msg)
+}
+
+// appendJSONLine appends v as a JSON line to the file at path
+func appendJSONLine(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
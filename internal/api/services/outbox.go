@@ -0,0 +1,570 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+var (
+	DefaultOutboxInterval  = time.Second
+	DefaultOutboxBatchSize = 100
+	DefaultOutboxBaseDelay = time.Second
+	DefaultOutboxMaxDelay  = time.Minute
+	DefaultOutboxTimeout   = 5 * time.Second
+)
+
+// outbox keeps the events of the committed transactions until the relay
+// handed them to every publisher, it is written under the lock of the
+// transactions so a message exists exactly when its transaction does. With
+// a store the messages survive a restart and are published again.
+type outbox struct {
+	sync.Mutex
+	seq uint64
+	// pending - the unpublished messages, the oldest first
+	pending []*outboxEntry
+	// wake tells the relay about a new message
+	wake  chan struct{}
+	store *outboxStore
+}
+
+type outboxEntry struct {
+	proto.OutboxMessage
+	// publications - the state of the message at every publisher of the
+	// relay, it is sized by the first due
+	publications []publication
+}
+
+// publication - the state of a message at one publisher
+type publication struct {
+	done      bool
+	attempts  int
+	lastError string
+	// next is the earliest time of the next attempt
+	next time.Time
+}
+
+// duePublication - a message the publisher of the index can take now, its
+// attempts are the ones at that publisher
+type duePublication struct {
+	publisher int
+	proto.OutboxMessage
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// openOutbox returns the outbox kept in the file at path with the messages
+// which were not published before the last stop, an empty path keeps the
+// messages in memory only
+func openOutbox(path string) (*outbox, error) {
+	o := newOutbox()
+	if path == "" {
+		return o, nil
+	}
+	seq, msgs, err := readOutbox(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	o.seq = seq
+	for i := range msgs {
+		o.pending = append(o.pending, &outboxEntry{OutboxMessage: msgs[i]})
+	}
+	o.store = &outboxStore{path: path}
+	if err := o.store.rewrite(seq, msgs); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// prepare numbers the events and writes them to the store, the messages
+// reach the relay with commit. The caller holds the lock of the
+// transactions, nothing is kept when it fails.
+func (o *outbox) prepare(events []proto.Event, now time.Time) ([]*outboxEntry, error) {
+	if o == nil {
+		return nil, nil
+	}
+	o.Lock()
+	defer o.Unlock()
+	entries := make([]*outboxEntry, 0, len(events))
+	for i, event := range events {
+		event.ID = o.seq + uint64(i) + 1
+		entries = append(entries, &outboxEntry{
+			OutboxMessage: proto.OutboxMessage{
+				ID:        event.ID,
+				Account:   event.Account,
+				Event:     event,
+				CreatedAt: now.Unix(),
+			},
+		})
+	}
+	records := make([]outboxRecord, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, outboxRecord{Message: &e.OutboxMessage})
+	}
+	if err := o.store.append(records...); err != nil {
+		return nil, err
+	}
+	o.seq += uint64(len(entries))
+	return entries, nil
+}
+
+// commit hands the prepared messages to the relay
+func (o *outbox) commit(entries []*outboxEntry) {
+	if o == nil || len(entries) == 0 {
+		return
+	}
+	o.Lock()
+	o.pending = append(o.pending, entries...)
+	o.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// abort drops the prepared messages of a transaction which was not
+// applied, the store marks them published so they are not replayed
+func (o *outbox) abort(entries []*outboxEntry) {
+	if o == nil || len(entries) == 0 {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	records := make([]outboxRecord, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, outboxRecord{Ack: e.ID})
+	}
+	if err := o.store.append(records...); err != nil {
+		slog.Error("outbox abort failed", "error", err)
+	}
+}
+
+// Pending returns the number of unpublished messages
+func (o *outbox) Pending() int {
+	if o == nil {
+		return 0
+	}
+	o.Lock()
+	defer o.Unlock()
+	return len(o.pending)
+}
+
+// Err returns the error of the last failed write of the store
+func (o *outbox) Err() error {
+	if o == nil {
+		return nil
+	}
+	o.Lock()
+	defer o.Unlock()
+	return o.store.Err()
+}
+
+// Close closes the file of the store, the unpublished messages stay in it
+func (o *outbox) Close() error {
+	if o == nil {
+		return nil
+	}
+	o.Lock()
+	defer o.Unlock()
+	return o.store.Close()
+}
+
+// due returns the publications of up to n messages which can be made now
+// to the publishers in the order of the messages, an account waiting for
+// the retry of a message at a publisher gets none of its later ones from it
+func (o *outbox) due(now time.Time, n, publishers int) []duePublication {
+	o.Lock()
+	defer o.Unlock()
+	resp := []duePublication{}
+	type key struct {
+		publisher int
+		account   string
+	}
+	waiting := make(map[key]bool)
+	messages := 0
+	for _, e := range o.pending {
+		if messages >= n {
+			break
+		}
+		if e.publications == nil {
+			e.publications = make([]publication, publishers)
+		}
+		found := false
+		for i := range e.publications {
+			p := &e.publications[i]
+			k := key{i, e.Account}
+			if p.done || waiting[k] {
+				continue
+			}
+			if p.next.After(now) {
+				waiting[k] = true
+				continue
+			}
+			msg := e.OutboxMessage
+			msg.Attempts = p.attempts
+			msg.LastError = p.lastError
+			resp = append(resp, duePublication{publisher: i, OutboxMessage: msg})
+			found = true
+		}
+		if found {
+			messages++
+		}
+	}
+	return resp
+}
+
+// ack records the publication of a message by a publisher, the message is
+// removed once every publisher took it
+func (o *outbox) ack(id uint64, publisher int) {
+	o.Lock()
+	defer o.Unlock()
+	for i, e := range o.pending {
+		if e.ID != id {
+			continue
+		}
+		e.publications[publisher].done = true
+		for _, p := range e.publications {
+			if !p.done {
+				return
+			}
+		}
+		o.pending = append(o.pending[:i], o.pending[i+1:]...)
+		o.acked(id)
+		return
+	}
+}
+
+// acked writes the removal of a published message to the store, the store
+// starts over once nothing is pending. A failed write only repeats the
+// publication after a restart. The caller holds the lock.
+func (o *outbox) acked(id uint64) {
+	var err error
+	if len(o.pending) == 0 {
+		err = o.store.rewrite(o.seq, nil)
+	} else {
+		err = o.store.append(outboxRecord{Ack: id})
+	}
+	if err != nil {
+		slog.Error("outbox store failed", "message", id, "error", err)
+	}
+}
+
+// fail records a failed publication, the message is due again at next
+func (o *outbox) fail(id uint64, publisher int, err error, next time.Time) {
+	o.Lock()
+	defer o.Unlock()
+	for _, e := range o.pending {
+		if e.ID == id {
+			p := &e.publications[publisher]
+			p.attempts++
+			p.lastError = err.Error()
+			p.next = next
+			return
+		}
+	}
+}
+
+// outboxRecord - a line of the store, a message which was added or the ID
+// of one which was published. The first line holds the last used ID.
+type outboxRecord struct {
+	Seq     uint64               `json:"seq,omitempty"`
+	Message *proto.OutboxMessage `json:"message,omitempty"`
+	Ack     uint64               `json:"ack,omitempty"`
+}
+
+// outboxStore appends the records of the outbox to a file, it is written
+// under the lock of the outbox
+type outboxStore struct {
+	path string
+	f    *os.File
+	// err - the error of the last write, nil once a write succeeds
+	err error
+}
+
+// readOutbox returns the last used ID and the unpublished messages of the
+// file at path, a missing file is an empty outbox
+func readOutbox(path string) (uint64, []proto.OutboxMessage, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var seq uint64
+	var msgs []proto.OutboxMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return 0, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		seq = max(seq, r.Seq)
+		switch {
+		case r.Message != nil:
+			seq = max(seq, r.Message.ID)
+			msgs = append(msgs, *r.Message)
+		case r.Ack != 0:
+			for i := range msgs {
+				if msgs[i].ID == r.Ack {
+					msgs = append(msgs[:i], msgs[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return seq, msgs, scanner.Err()
+}
+
+// append writes the records at the end of the file
+func (s *outboxStore) append(records ...outboxRecord) error {
+	if s == nil {
+		return nil
+	}
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if s.f == nil {
+		s.err = os.ErrClosed
+		return s.err
+	}
+	_, s.err = s.f.Write(buf)
+	return s.err
+}
+
+// rewrite replaces the file with the last used ID and the messages, the
+// new file is renamed over the old one so a crash keeps either of them
+func (s *outboxStore) rewrite(seq uint64, msgs []proto.OutboxMessage) error {
+	if s == nil {
+		return nil
+	}
+	s.err = s.replace(seq, msgs)
+	return s.err
+}
+
+func (s *outboxStore) replace(seq uint64, msgs []proto.OutboxMessage) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(outboxRecord{Seq: seq})
+	for i := range msgs {
+		if err == nil {
+			err = enc.Encode(outboxRecord{Message: &msgs[i]})
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err = errors.Join(err, f.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	next, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = next
+	return nil
+}
+
+// Err returns the error of the last write
+func (s *outboxStore) Err() error {
+	if s == nil {
+		return nil
+	}
+	return s.err
+}
+
+// Close syncs and closes the file, the later writes fail
+func (s *outboxStore) Close() error {
+	if s == nil || s.f == nil {
+		return nil
+	}
+	err := errors.Join(s.f.Sync(), s.f.Close())
+	s.f = nil
+	return err
+}
+
+// relay drains the outbox to the publishers, a message is removed only once
+// every publisher took it so every event is published at least once, and
+// the messages of an account reach every publisher in their order. A
+// publisher which fails holds back only its own later messages.
+type relay struct {
+	cfg        config.OutboxConfig
+	outbox     *outbox
+	publishers []Publisher
+	clock      clock.Clock
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// newRelay returns a relay of o to the publishers, the messages are due and
+// retried by the time of clk
+func newRelay(cfg config.OutboxConfig, o *outbox, clk clock.Clock, publishers ...Publisher) *relay {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultOutboxInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultOutboxBatchSize
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultOutboxBaseDelay
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = max(DefaultOutboxMaxDelay, cfg.BaseDelay)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultOutboxTimeout
+	}
+	return &relay{
+		cfg:        cfg,
+		outbox:     o,
+		publishers: publishers,
+		clock:      clock.Or(clk),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start runs the relay in the background until Close
+func (r *relay) Start() {
+	go r.run()
+}
+
+// Close stops the relay after a last publication of the due messages and
+// closes the publishers, the unpublished messages stay in the outbox
+func (r *relay) Close() error {
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.stop:
+		return nil
+	default:
+		close(r.stop)
+	}
+	<-r.done
+	var err error
+	for _, p := range r.publishers {
+		if c, ok := p.(io.Closer); ok {
+			err = errors.Join(err, c.Close())
+		}
+	}
+	return err
+}
+
+func (r *relay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		r.drain()
+		select {
+		case <-r.stop:
+			// a last pass publishes the messages of the requests which
+			// finished during the shutdown
+			r.publish(r.outbox.due(r.clock.Now(), r.outbox.Pending(), len(r.publishers)))
+			return
+		case <-r.outbox.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain publishes the due messages until none is left, a failed message
+// holds back the later ones of its account at its publisher until its retry
+func (r *relay) drain() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		pubs := r.outbox.due(r.clock.Now(), r.cfg.BatchSize, len(r.publishers))
+		if len(pubs) == 0 {
+			return
+		}
+		r.publish(pubs)
+	}
+}
+
+// publish makes the publications in their order, a failed one holds back
+// the later ones of its account at its publisher
+func (r *relay) publish(pubs []duePublication) {
+	type key struct {
+		publisher int
+		account   string
+	}
+	failed := make(map[key]bool)
+	for _, pub := range pubs {
+		k := key{pub.publisher, pub.Account}
+		if failed[k] {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+		err := r.publishers[pub.publisher].Publish(ctx, pub.OutboxMessage)
+		cancel()
+		if err != nil {
+			failed[k] = true
+			delay := r.backoff(pub.Attempts + 1)
+			r.outbox.fail(pub.ID, pub.publisher, err, r.clock.Now().Add(delay))
+			slog.Warn("outbox publish failed",
+				"message", pub.ID,
+				"account", pub.Account,
+				"publisher", fmt.Sprintf("%T", r.publishers[pub.publisher]),
+				"attempts", pub.Attempts+1,
+				"retry_in", delay,
+				"error", err,
+			)
+			continue
+		}
+		r.outbox.ack(pub.ID, pub.publisher)
+	}
+}
+
+// backoff returns the wait after the nth failed publication
+func (r *relay) backoff(n int) time.Duration {
+	delay := r.cfg.BaseDelay
+	for i := 1; i < n && delay < r.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.cfg.MaxDelay)
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// memoryPublisher keeps the published messages, it fails while fail is
+// above zero
+type memoryPublisher struct {
+	sync.Mutex
+	fail int
+	msgs []proto.OutboxMessage
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("unavailable")
+	}
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func (p *memoryPublisher) published() []proto.OutboxMessage {
+	p.Lock()
+	defer p.Unlock()
+	return append([]proto.OutboxMessage(nil), p.msgs...)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{})
+	service.outbox = newOutbox()
+	publisher := &memoryPublisher{fail: 1}
+	clk := service.clock.(*clock.Fake)
+	service.relay = newRelay(config.OutboxConfig{BaseDelay: time.Minute}, service.outbox, clk, publisher)
+
+	// a failed write leaves no message
+	_, _, err := service.Withdraw(ctx, proto.Transaction{From: "test2", Amount: 100}, "test2-nonce")
+	if !errors.Is(err, ErrBalanceNotEnough) {
+		t.Fatalf("Expected error: %v, got: %v", ErrBalanceNotEnough, err)
+	}
+	if n := service.outbox.Pending(); n != 0 {
+		t.Fatalf("Expected pending: %v, got: %v", 0, n)
+	}
+
+	// the messages wait for the relay
+	nonce := "test-nonce"
+	for i := 1; i <= 3; i++ {
+		_, nonce, err = service.Deposit(ctx, proto.Transaction{To: "test", Amount: i}, nonce)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	if _, _, err := service.Transaction(ctx, proto.Transaction{From: "test", To: "test2", Amount: 4}, nonce); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if n := service.outbox.Pending(); n != 5 {
+		t.Fatalf("Expected pending: %v, got: %v", 5, n)
+	}
+
+	// the failed message holds back its account until it is due again
+	service.relay.drain()
+	if n := service.outbox.Pending(); n != 4 {
+		t.Fatalf("Expected pending: %v, got: %v", 4, n)
+	}
+	clk.Advance(time.Minute - time.Second)
+	service.relay.drain()
+	if n := service.outbox.Pending(); n != 4 {
+		t.Fatalf("Expected pending: %v, got: %v", 4, n)
+	}
+
+	// the failed publications are retried and the order of every account holds
+	clk.Advance(time.Second)
+	service.relay.drain()
+	if n := service.outbox.Pending(); n != 0 {
+		t.Fatalf("Expected pending: %v, got: %v", 0, n)
+	}
+
+	msgs := publisher.published()
+	if len(msgs) != 5 {
+		t.Fatalf("Expected messages: %v, got: %v", 5, len(msgs))
+	}
+	first := make(map[string]proto.OutboxMessage)
+	last := make(map[string]uint64)
+	for _, msg := range msgs {
+		if msg.ID <= last[msg.Account] {
+			t.Fatalf("Expected message %v of %s after %v", msg.ID, msg.Account, last[msg.Account])
+		}
+		if _, ok := first[msg.Account]; !ok {
+			first[msg.Account] = msg
+		}
+		last[msg.Account] = msg.ID
+	}
+	if msg := first["test"]; msg.Attempts != 1 || msg.Event.Balance != 101 {
+		t.Fatalf("Expected the first deposit after %v failed attempts, got: %v", 1, msg)
+	}
+}
+
+func TestOutboxStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	service := newWebhookBank(config.WebhooksConfig{})
+	o, err := openOutbox(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	service.outbox = o
+	clk := service.clock.(*clock.Fake)
+	publisher := &memoryPublisher{fail: 1}
+	service.relay = newRelay(config.OutboxConfig{BaseDelay: time.Minute}, o, clk, publisher)
+
+	nonce := "test-nonce"
+	for i := 1; i <= 2; i++ {
+		if _, nonce, err = service.Deposit(ctx, proto.Transaction{To: "test", Amount: i}, nonce); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	if _, _, err := service.Deposit(ctx, proto.Transaction{To: "test2", Amount: 1}, "test2-nonce"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	// the first message fails and holds back the second one
+	service.relay.drain()
+	if err := o.Close(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// the unpublished messages are replayed after a restart
+	o, err = openOutbox(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer o.Close()
+	if n := o.Pending(); n != 2 {
+		t.Fatalf("Expected pending: %v, got: %v", 2, n)
+	}
+	service.outbox = o
+	publisher = &memoryPublisher{}
+	service.relay = newRelay(config.OutboxConfig{}, o, clk, publisher)
+	if _, _, err := service.Deposit(ctx, proto.Transaction{To: "test", Amount: 3}, nonce); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	service.relay.drain()
+	msgs := publisher.published()
+	if len(msgs) != 3 {
+		t.Fatalf("Expected messages: %v, got: %v", 3, len(msgs))
+	}
+	for i, id := range []uint64{1, 2, 4} {
+		if msgs[i].ID != id || msgs[i].Event.ID != id {
+			t.Fatalf("Expected message: %v, got: %v", id, msgs[i])
+		}
+	}
+
+	// the published outbox starts over and keeps the last ID
+	if err := o.Close(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	o, err = openOutbox(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer o.Close()
+	entries, err := o.prepare([]proto.Event{{Account: "test"}}, start)
+	if err != nil || o.Pending() != 0 || entries[0].ID != 5 {
+		t.Fatalf("Expected message: %v of an empty outbox, got: %v, %v", 5, entries, err)
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		reader := bufio.NewReader(conn)
+		lines := []string{}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			lines = append(lines, line)
+			if line == "PING" {
+				conn.Write([]byte("PONG\r\n"))
+				received <- lines
+				lines = []string{}
+			}
+		}
+	}()
+
+	publisher := newNATSPublisher("nats://"+listener.Addr().String(), "")
+	defer publisher.Close()
+	sctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	msg := proto.OutboxMessage{ID: 1, Account: "test", Event: proto.Event{Type: proto.EventCredited, Account: "test"}}
+	if err := publisher.Publish(sctx, msg); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	lines := <-received
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "CONNECT ") {
+		t.Fatalf("Expected CONNECT, PUB, payload and PING, got: %v", lines)
+	}
+	if !strings.HasPrefix(lines[1], "PUB "+DefaultNATSSubject+".test ") {
+		t.Fatalf("Expected subject: %v, got: %v", DefaultNATSSubject+".test", lines[1])
+	}
+	var got proto.OutboxMessage
+	if err := json.Unmarshal([]byte(lines[2]), &got); err != nil || got.ID != msg.ID {
+		t.Fatalf("Expected message: %v, got: %v", msg.ID, lines[2])
+	}
+}
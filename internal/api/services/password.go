@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+var (
+	ResetTokenLen        = 32
+	DefaultResetTokenTTL = 15 * time.Minute
+)
+
+type resetToken struct {
+	account  string
+	expireAt time.Time
+}
+
+// resetMap holds the unused reset tokens by their hash
+type resetMap struct {
+	sync.Mutex
+	ttl  time.Duration
+	data map[string]resetToken
+}
+
+func newResetMap(ttl time.Duration) *resetMap {
+	if ttl <= 0 {
+		ttl = DefaultResetTokenTTL
+	}
+	return &resetMap{
+		ttl:  ttl,
+		data: make(map[string]resetToken),
+	}
+}
+
+func (b *bank) ChangePassword(ctx context.Context, account, oldPwd, newPwd string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+
+	if utils.IsEmpty(oldPwd) || utils.IsEmpty(newPwd) {
+		return ErrEmptyPwd
+	}
+
+	errs := &policy.ValidationError{}
+	b.policy.ValidatePassword("new_password", newPwd, errs)
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	ip := clientIP(ctx)
+	if err := b.checkGuard(ctx, account, ip); err != nil {
+		return err
+	}
+
+	b.users.Lock()
+	user, ok := b.users.data[account]
+	if !ok || user.Password != oldPwd {
+		b.users.Unlock()
+		b.guard.Fail(ctx, account, ip, b.now())
+		return ErrVerify
+	}
+	if err := b.setPassword(&user, newPwd); err != nil {
+		b.users.Unlock()
+		return err
+	}
+	b.users.Unlock()
+
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditPasswordChanged,
+		Account: account,
+		IP:      ip,
+	})
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditNonceRotated,
+		Account: account,
+		Detail:  proto.AuditPasswordChanged,
+	})
+	return nil
+}
+
+func (b *bank) RequestPasswordReset(ctx context.Context, account string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+
+	b.users.RLock()
+	_, ok := b.users.data[account]
+	b.users.RUnlock()
+
+	// do not reveal whether the account exists
+	if !ok {
+		return nil
+	}
+
+	token, err := utils.GenerateNonce(ResetTokenLen)
+	if err != nil {
+		return err
+	}
+	now := b.now()
+	expireAt := now.Add(b.resets.ttl)
+
+	b.resets.Lock()
+	// only the latest token of an account is usable
+	for hash, t := range b.resets.data {
+		if t.account == account || now.After(t.expireAt) {
+			delete(b.resets.data, hash)
+		}
+	}
+	b.resets.data[hashResetToken(token)] = resetToken{
+		account:  account,
+		expireAt: expireAt,
+	}
+	b.resets.Unlock()
+
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditPasswordResetRequested,
+		Account: account,
+		IP:      clientIP(ctx),
+	})
+
+	err = b.notifier.Notify(ctx, proto.Notification{
+		Account: account,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use the token %s to reset your password before %s.",
+			token, expireAt.UTC().Format(time.RFC3339)),
+		CreatedAt: now.Unix(),
+	})
+	if err != nil {
+		// the answer stays the one of an unknown account
+		slog.ErrorContext(ctx, "password reset notification failed", "account", account, "error", err)
+	}
+	return nil
+}
+
+func (b *bank) ResetPassword(ctx context.Context, token, newPwd string) error {
+	if utils.IsEmpty(token) {
+		return ErrResetToken
+	}
+
+	if utils.IsEmpty(newPwd) {
+		return ErrEmptyPwd
+	}
+
+	errs := &policy.ValidationError{}
+	b.policy.ValidatePassword("new_password", newPwd, errs)
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	hash := hashResetToken(token)
+	b.resets.Lock()
+	t, ok := b.resets.data[hash]
+	delete(b.resets.data, hash)
+	b.resets.Unlock()
+
+	if !ok || b.now().After(t.expireAt) {
+		return ErrResetToken
+	}
+
+	b.users.Lock()
+	user, ok := b.users.data[t.account]
+	if !ok {
+		b.users.Unlock()
+		return ErrResetToken
+	}
+	if err := b.setPassword(&user, newPwd); err != nil {
+		b.users.Unlock()
+		return err
+	}
+	b.users.Unlock()
+
+	b.guard.Succeed(t.account)
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditPasswordReset,
+		Account: t.account,
+		IP:      clientIP(ctx),
+	})
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditNonceRotated,
+		Account: t.account,
+		Detail:  proto.AuditPasswordReset,
+	})
+	return nil
+}
+
+// setPassword stores the new password of the user, rotates the nonce and
+// revokes every session so a stolen token stops working as well, the caller
+// holds the users lock
+func (b *bank) setPassword(user *proto.User, pwd string) error {
+	nonce, err := utils.GenerateNonce(NonceLen)
+	if err != nil {
+		return err
+	}
+	now := b.now()
+	user.Password = pwd
+	user.Nonce = nonce
+	user.UpdatedAt = now.Unix()
+	b.users.data[user.Account] = *user
+	b.tokens.RevokeAll(user.Account, now)
+	return nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+type memoryNotifier struct {
+	messages []proto.Notification
+}
+
+func (n *memoryNotifier) Notify(ctx context.Context, msg proto.Notification) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// failingNotifier fails every notification like an unwritable file
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, msg proto.Notification) error {
+	return errors.New("permission denied")
+}
+
+var resetTokenPattern = regexp.MustCompile(`token (\S+) `)
+
+func newPasswordBank(notifier Notifier) *bank {
+	service := &bank{
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		resets:   newResetMap(0),
+		tokens:   newTokenStore(),
+		notifier: notifier,
+	}
+	service.users.data["test"] = proto.User{
+		Account:  "test",
+		Balance:  100,
+		Password: "test-pwd",
+		Name:     "test-user",
+		Nonce:    "test-nonce",
+	}
+	return service
+}
+
+func TestChangePassword(t *testing.T) {
+	service := newPasswordBank(nil)
+	token := &proto.UserToken{ID: "t1", Account: "test", ExpireAt: time.Now().Add(time.Minute).Unix()}
+	if err := service.TrackToken(ctx, token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err := service.ChangePassword(ctx, "test", "t", "new-pwd")
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+	err = service.ChangePassword(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrEmptyPwd) {
+		t.Fatalf("Expected error: %v, got: %v", ErrEmptyPwd, err)
+	}
+
+	if err := service.ChangePassword(ctx, "test", "test-pwd", "new-pwd"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the outstanding token can not write nor read anymore
+	_, _, err = service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 10}, "test-nonce")
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+	err = service.VerifyToken(ctx, token)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTokenRevoked, err)
+	}
+
+	_, err = service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrVerify) {
+		t.Fatalf("Expected error: %v, got: %v", ErrVerify, err)
+	}
+	if _, err := service.GetNonce(ctx, "test", "new-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	notifier := &memoryNotifier{}
+	service := newPasswordBank(notifier)
+	stolen := &proto.UserToken{ID: "t1", Account: "test", ExpireAt: time.Now().Add(time.Minute).Unix()}
+	if err := service.TrackToken(ctx, stolen); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// unknown accounts are not revealed
+	if err := service.RequestPasswordReset(ctx, "t"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(notifier.messages) != 0 {
+		t.Fatalf("Expected messages: 0, got: %v", len(notifier.messages))
+	}
+
+	if err := service.RequestPasswordReset(ctx, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(notifier.messages) != 1 || notifier.messages[0].Account != "test" {
+		t.Fatalf("Expected a message to test, got: %v", notifier.messages)
+	}
+	match := resetTokenPattern.FindStringSubmatch(notifier.messages[0].Body)
+	if match == nil {
+		t.Fatalf("Expected a token in: %v", notifier.messages[0].Body)
+	}
+	token := match[1]
+
+	err := service.ResetPassword(ctx, "t", "new-pwd")
+	if !errors.Is(err, ErrResetToken) {
+		t.Fatalf("Expected error: %v, got: %v", ErrResetToken, err)
+	}
+	if err := service.ResetPassword(ctx, token, "new-pwd"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the reset evicts the holder of a stolen token
+	err = service.VerifyToken(ctx, stolen)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTokenRevoked, err)
+	}
+	if _, err := service.GetNonce(ctx, "test", "new-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the token works only once
+	err = service.ResetPassword(ctx, token, "other-pwd")
+	if !errors.Is(err, ErrResetToken) {
+		t.Fatalf("Expected error: %v, got: %v", ErrResetToken, err)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	notifier := &memoryNotifier{}
+	service := newPasswordBank(notifier)
+	clk := clock.NewFake(start)
+	service.clock = clk
+
+	if err := service.RequestPasswordReset(ctx, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token := resetTokenPattern.FindStringSubmatch(notifier.messages[0].Body)[1]
+	clk.Advance(service.resets.ttl + time.Second)
+
+	err := service.ResetPassword(ctx, token, "new-pwd")
+	if !errors.Is(err, ErrResetToken) {
+		t.Fatalf("Expected error: %v, got: %v", ErrResetToken, err)
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	service := newPasswordBank(&logNotifier{})
+	if err := service.RequestPasswordReset(ctx, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Password reset") {
+		t.Fatalf("Expected the subject in the log, got: %v", buf.String())
+	}
+	if strings.Contains(buf.String(), "Use the token") {
+		t.Fatalf("Expected no body in the log, got: %v", buf.String())
+	}
+}
+
+func TestRequestPasswordResetNotifierFailure(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	// a known and an unknown account get the same answer
+	service := newPasswordBank(failingNotifier{})
+	for _, account := range []string{"test", "t"} {
+		if err := service.RequestPasswordReset(ctx, account); err != nil {
+			t.Fatalf("Unexpected error for %s: %v", account, err)
+		}
+	}
+	if !strings.Contains(buf.String(), "permission denied") {
+		t.Fatalf("Expected the error in the log, got: %v", buf.String())
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+var (
+	DefaultNATSSubject = "bank.events"
+
+	ErrNATSProtocol = errors.New("unexpected nats reply")
+)
+
+// Publisher hands the messages of the outbox to the consumers, a message
+// counts as published once Publish returned nil
+type Publisher interface {
+	Publish(ctx context.Context, msg proto.OutboxMessage) error
+}
+
+// NewPublisher returns the publisher of the configured type, it falls back
+// to the log publisher
+func NewPublisher(cfg config.OutboxConfig) Publisher {
+	switch cfg.Publisher {
+	case "file":
+		return &filePublisher{path: cfg.Path}
+	case "nats":
+		return newNATSPublisher(cfg.URL, cfg.Subject)
+	default:
+		return &logPublisher{}
+	}
+}
+
+type logPublisher struct{}
+
+func (p *logPublisher) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	slog.InfoContext(ctx, "publish", "message", msg.ID, "type", msg.Event.Type, "account", msg.Account)
+	return nil
+}
+
+// filePublisher appends every message as a JSON line to a file, it is
+// meant for local testing
+type filePublisher struct {
+	sync.Mutex
+	path string
+}
+
+func (p *filePublisher) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	p.Lock()
+	defer p.Unlock()
+	return appendJSONLine(p.path, msg)
+}
+
+// natsPublisher speaks the text protocol of NATS, a message goes to the
+// subject "<subject>.<account>" and is published once the server answered
+// the PING which follows it
+type natsPublisher struct {
+	sync.Mutex
+	addr    string
+	subject string
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newNATSPublisher(rawURL, subject string) *natsPublisher {
+	addr := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	if subject == "" {
+		subject = DefaultNATSSubject
+	}
+	return &natsPublisher{addr: addr, subject: subject}
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	p.conn.SetDeadline(deadline)
+
+	_, err = fmt.Fprintf(p.conn, "PUB %s.%s %d\r\n%s\r\nPING\r\n", p.subject, msg.Account, len(body), body)
+	if err == nil {
+		err = p.pong()
+	}
+	if err != nil {
+		// the state of the connection is unknown, the next message dials again
+		p.close()
+		return err
+	}
+	return nil
+}
+
+func (p *natsPublisher) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.close()
+}
+
+// connect dials the server, reads its INFO and sends the CONNECT
+func (p *natsPublisher) connect(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO") {
+		err = fmt.Errorf("%w: %s", ErrNATSProtocol, strings.TrimSpace(line))
+	}
+	if err == nil {
+		_, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"bank\"}\r\n")
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	p.reader = reader
+	return nil
+}
+
+// pong waits for the answer of a PING, it answers the PINGs of the server
+func (p *natsPublisher) pong() error {
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := fmt.Fprint(p.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("%w: %s", ErrNATSProtocol, line)
+		}
+	}
+}
+
+func (p *natsPublisher) close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	p.reader = nil
+	return err
+}
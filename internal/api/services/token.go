@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+// tokenStore keeps the session of every issued token and the revocation
+// list keyed by the token ID, both forget a token once it expires
+type tokenStore struct {
+	sync.Mutex
+	// sessions - account -> token ID -> session
+	sessions map[string]map[string]*proto.Session
+	// revoked - token ID -> expire at
+	revoked   map[string]int64
+	lastPrune time.Time
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{
+		sessions: make(map[string]map[string]*proto.Session),
+		revoked:  make(map[string]int64),
+	}
+}
+
+// Issue opens the session of the token
+func (s *tokenStore) Issue(token *proto.UserToken, ip, ua string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.prune(now)
+	sessions, ok := s.sessions[token.Account]
+	if !ok {
+		sessions = make(map[string]*proto.Session)
+		s.sessions[token.Account] = sessions
+	}
+	sessions[token.ID] = &proto.Session{
+		ID:         token.ID,
+		IP:         ip,
+		UserAgent:  ua,
+		CreatedAt:  token.CreatedAt,
+		LastSeenAt: token.CreatedAt,
+		ExpireAt:   token.ExpireAt,
+	}
+}
+
+// Touch updates the last seen time of the session
+func (s *tokenStore) Touch(token *proto.UserToken, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if session, ok := s.sessions[token.Account][token.ID]; ok {
+		session.LastSeenAt = now.Unix()
+	}
+}
+
+// Sessions returns the sessions of the account open at now, the newest first
+func (s *tokenStore) Sessions(account string, now time.Time) []proto.Session {
+	resp := []proto.Session{}
+	if s == nil {
+		return resp
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, session := range s.sessions[account] {
+		if now.Unix() <= session.ExpireAt {
+			resp = append(resp, *session)
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].CreatedAt > resp[j].CreatedAt
+	})
+	return resp
+}
+
+// Revoke closes the session of the token and adds it to the revocation list
+func (s *tokenStore) Revoke(token *proto.UserToken, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.prune(now)
+	delete(s.sessions[token.Account], token.ID)
+	s.revoked[token.ID] = token.ExpireAt
+}
+
+// RevokeSession revokes the session only if it belongs to the account, it
+// reports whether the account had the session
+func (s *tokenStore) RevokeSession(account, id string, now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.prune(now)
+	session, ok := s.sessions[account][id]
+	if !ok {
+		return false
+	}
+	delete(s.sessions[account], id)
+	s.revoked[id] = session.ExpireAt
+	return true
+}
+
+// RevokeAll revokes every session of the account, it returns the number
+// of revoked sessions
+func (s *tokenStore) RevokeAll(account string, now time.Time) int {
+	if s == nil {
+		return 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.prune(now)
+	sessions := s.sessions[account]
+	for id, session := range sessions {
+		s.revoked[id] = session.ExpireAt
+	}
+	delete(s.sessions, account)
+	return len(sessions)
+}
+
+// IsRevoked reports whether the token is in the revocation list
+func (s *tokenStore) IsRevoked(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.revoked[id]
+	return ok
+}
+
+// prune drops the expired tokens, at most once a minute
+func (s *tokenStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	unix := now.Unix()
+	for id, expireAt := range s.revoked {
+		if unix > expireAt {
+			delete(s.revoked, id)
+		}
+	}
+	for account, sessions := range s.sessions {
+		for id, session := range sessions {
+			if unix > session.ExpireAt {
+				delete(sessions, id)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(s.sessions, account)
+		}
+	}
+}
+
+func (b *bank) TrackToken(ctx context.Context, token *proto.UserToken) error {
+	if token == nil || utils.IsEmpty(token.ID) {
+		return ErrInvalidToken
+	}
+	b.tokens.Issue(token, clientIP(ctx), userAgent(ctx), b.now())
+	return nil
+}
+
+// VerifyToken rejects revoked tokens and refreshes the last seen time of
+// the session of valid ones
+func (b *bank) VerifyToken(ctx context.Context, token *proto.UserToken) error {
+	if token == nil || utils.IsEmpty(token.ID) {
+		return ErrInvalidToken
+	}
+	if b.tokens.IsRevoked(token.ID) {
+		return ErrTokenRevoked
+	}
+	b.tokens.Touch(token, b.now())
+	return nil
+}
+
+func (b *bank) Logout(ctx context.Context, token *proto.UserToken) error {
+	if token == nil || utils.IsEmpty(token.ID) {
+		return ErrInvalidToken
+	}
+	b.tokens.Revoke(token, b.now())
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditLogout,
+		Account: token.Account,
+		IP:      clientIP(ctx),
+	})
+	return nil
+}
+
+func (b *bank) LogoutAll(ctx context.Context, account string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+	n := b.tokens.RevokeAll(account, b.now())
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditLogoutAll,
+		Account: account,
+		IP:      clientIP(ctx),
+		Detail:  fmt.Sprintf("%d sessions revoked", n),
+	})
+	return nil
+}
+
+func (b *bank) GetSessions(ctx context.Context, token *proto.UserToken) ([]proto.Session, error) {
+	if token == nil || utils.IsEmpty(token.Account) {
+		return []proto.Session{}, ErrEmptyAccount
+	}
+	sessions := b.tokens.Sessions(token.Account, b.now())
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == token.ID
+	}
+	return sessions, nil
+}
+
+func (b *bank) RevokeSession(ctx context.Context, account, id string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+	if utils.IsEmpty(id) {
+		return ErrSessionNotFound
+	}
+
+	if !b.tokens.RevokeSession(account, id, b.now()) {
+		return ErrSessionNotFound
+	}
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditSessionRevoked,
+		Account: account,
+		IP:      clientIP(ctx),
+		Detail:  id,
+	})
+	return nil
+}
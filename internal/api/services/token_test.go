@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// newTokenBank returns a bank tracking tokens whose clock stands at start
+func newTokenBank() (*bank, *clock.Fake) {
+	clk := clock.NewFake(start)
+	return &bank{tokens: newTokenStore(), clock: clk}, clk
+}
+
+// newToken returns a token issued at start
+func newToken(id, account string, expire time.Duration) *proto.UserToken {
+	now := start
+	return &proto.UserToken{
+		ID:        id,
+		Account:   account,
+		ExpireAt:  now.Add(expire).Unix(),
+		CreatedAt: now.Unix(),
+	}
+}
+
+func TestLogout(t *testing.T) {
+	service, _ := newTokenBank()
+	token := newToken("t1", "test", time.Minute)
+	other := newToken("t2", "test", time.Minute)
+
+	for _, tk := range []*proto.UserToken{token, other} {
+		if err := service.TrackToken(ctx, tk); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err := service.Logout(ctx, token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err := service.VerifyToken(ctx, token)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTokenRevoked, err)
+	}
+	if err := service.VerifyToken(ctx, other); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = service.VerifyToken(ctx, &proto.UserToken{Account: "test"})
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected error: %v, got: %v", ErrInvalidToken, err)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	service, _ := newTokenBank()
+	tokens := []*proto.UserToken{
+		newToken("t1", "test", time.Minute),
+		newToken("t2", "test", time.Minute),
+	}
+	other := newToken("t3", "test2", time.Minute)
+	for _, tk := range append(tokens, other) {
+		if err := service.TrackToken(ctx, tk); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err := service.LogoutAll(ctx, "test"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, tk := range tokens {
+		err := service.VerifyToken(ctx, tk)
+		if !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("Expected error: %v, got: %v", ErrTokenRevoked, err)
+		}
+	}
+	if err := service.VerifyToken(ctx, other); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTokenStorePrune(t *testing.T) {
+	store := newTokenStore()
+	expired := newToken("t1", "test", -time.Minute)
+	valid := newToken("t2", "test", time.Minute)
+	store.Issue(expired, "", "", start)
+	store.Issue(valid, "", "", start)
+	store.Revoke(expired, start)
+	store.Revoke(valid, start)
+
+	// the store prunes at most once a minute
+	store.Lock()
+	store.prune(start.Add(time.Second))
+	store.Unlock()
+	if !store.IsRevoked(expired.ID) {
+		t.Fatalf("Expected expired token to be kept until the next prune")
+	}
+
+	store.Lock()
+	store.prune(start.Add(time.Minute))
+	store.Unlock()
+
+	if store.IsRevoked(expired.ID) {
+		t.Fatalf("Expected expired token to be pruned")
+	}
+	if !store.IsRevoked(valid.ID) {
+		t.Fatalf("Expected valid token to stay revoked")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	service, clk := newTokenBank()
+	token := newToken("t1", "test", time.Minute)
+	other := newToken("t2", "test", time.Minute)
+	other.CreatedAt++
+	foreign := newToken("t3", "test2", time.Minute)
+
+	if err := service.TrackToken(WithUserAgent(WithClientIP(ctx, "10.0.0.1"), "curl"), token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, tk := range []*proto.UserToken{other, foreign} {
+		if err := service.TrackToken(ctx, tk); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	sessions, err := service.GetSessions(ctx, token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected sessions: 2, got: %v", len(sessions))
+	}
+	if sessions[0].ID != "t2" || sessions[0].Current {
+		t.Fatalf("Unexpected first session: %+v", sessions[0])
+	}
+	if sessions[1].ID != "t1" || !sessions[1].Current || sessions[1].IP != "10.0.0.1" || sessions[1].UserAgent != "curl" {
+		t.Fatalf("Unexpected second session: %+v", sessions[1])
+	}
+
+	// sessions of other accounts can not be revoked
+	err = service.RevokeSession(ctx, "test", foreign.ID)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrSessionNotFound, err)
+	}
+	if err := service.VerifyToken(ctx, foreign); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := service.RevokeSession(ctx, "test", other.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = service.VerifyToken(ctx, other)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTokenRevoked, err)
+	}
+	sessions, _ = service.GetSessions(ctx, token)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected sessions: 1, got: %v", len(sessions))
+	}
+
+	// the session closes when its token expires
+	clk.Advance(time.Minute + time.Second)
+	sessions, _ = service.GetSessions(ctx, token)
+	if len(sessions) != 0 {
+		t.Fatalf("Expected sessions: 0, got: %v", len(sessions))
+	}
+}
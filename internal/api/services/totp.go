@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+var (
+	RecoveryCodeCount = 8
+	DefaultTOTPIssuer = "simple-bank"
+)
+
+type totpEnrollment struct {
+	secret   string
+	verified bool
+	// recoveryCodes holds the hashes of the unused recovery codes
+	recoveryCodes map[string]struct{}
+	// lastStep is the last accepted time step, codes of older or the same
+	// step are refused to prevent a replay
+	lastStep int64
+}
+
+type totpMap struct {
+	sync.Mutex
+	cfg  config.TOTPConfig
+	data map[string]*totpEnrollment
+}
+
+func newTOTPMap(cfg config.TOTPConfig) *totpMap {
+	if utils.IsEmpty(cfg.Issuer) {
+		cfg.Issuer = DefaultTOTPIssuer
+	}
+	if cfg.Skew <= 0 {
+		cfg.Skew = 1
+	}
+	return &totpMap{
+		cfg:  cfg,
+		data: make(map[string]*totpEnrollment),
+	}
+}
+
+func (b *bank) EnrollTOTP(ctx context.Context, account string) (*proto.TOTPEnrollment, error) {
+	if utils.IsEmpty(account) {
+		return nil, ErrEmptyAccount
+	}
+
+	b.users.RLock()
+	_, ok := b.users.data[account]
+	b.users.RUnlock()
+
+	if !ok {
+		return nil, ErrAccountNotExist
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	b.totps.Lock()
+	defer b.totps.Unlock()
+	if e, ok := b.totps.data[account]; ok && e.verified {
+		return nil, ErrTOTPEnrolled
+	}
+	b.totps.data[account] = &totpEnrollment{
+		secret:        secret,
+		recoveryCodes: hashes,
+	}
+
+	return &proto.TOTPEnrollment{
+		Secret:        secret,
+		URI:           utils.TOTPURI(b.totps.cfg.Issuer, account, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+func (b *bank) ConfirmTOTP(ctx context.Context, account, code string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+
+	ip := clientIP(ctx)
+	if err := b.checkGuard(ctx, account, ip); err != nil {
+		return err
+	}
+
+	b.totps.Lock()
+	defer b.totps.Unlock()
+	e, ok := b.totps.data[account]
+	if !ok {
+		return ErrTOTPNotEnrolled
+	}
+	if e.verified {
+		return ErrTOTPEnrolled
+	}
+
+	step, ok := utils.ValidateTOTP(e.secret, code, b.now(), b.totps.cfg.Skew)
+	if !ok {
+		b.guard.Fail(ctx, account, ip, b.now())
+		return ErrTOTPInvalid
+	}
+	e.verified = true
+	e.lastStep = step
+	b.record(ctx, proto.AuditEvent{
+		Type:    proto.AuditTOTPEnabled,
+		Account: account,
+		IP:      clientIP(ctx),
+	})
+	return nil
+}
+
+func (b *bank) StepUp(ctx context.Context, account string, amount int, code string) error {
+	if b.totps == nil || b.totps.cfg.StepUpAmount <= 0 || amount <= b.totps.cfg.StepUpAmount {
+		return nil
+	}
+
+	// a wrong code counts as a failed login, the guard bounds the guesses
+	// of a stolen token like the ones of a password
+	ip := clientIP(ctx)
+	if err := b.checkGuard(ctx, account, ip); err != nil {
+		return err
+	}
+	err := b.verifySecondFactor(account, code)
+	if errors.Is(err, ErrTOTPInvalid) {
+		b.guard.Fail(ctx, account, ip, b.now())
+	}
+	return err
+}
+
+// verifySecondFactor checks a totp code or an unused recovery code of the
+// account, accounts without a verified enrollment always pass. The caller
+// checks the login guard and reports ErrTOTPInvalid to it
+func (b *bank) verifySecondFactor(account, code string) error {
+	if b.totps == nil {
+		return nil
+	}
+
+	b.totps.Lock()
+	defer b.totps.Unlock()
+	e, ok := b.totps.data[account]
+	if !ok || !e.verified {
+		return nil
+	}
+
+	if utils.IsEmpty(code) {
+		return ErrTOTPRequired
+	}
+
+	if step, ok := utils.ValidateTOTP(e.secret, code, b.now(), b.totps.cfg.Skew); ok && step > e.lastStep {
+		e.lastStep = step
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	if _, ok := e.recoveryCodes[hash]; ok {
+		delete(e.recoveryCodes, hash)
+		return nil
+	}
+	return ErrTOTPInvalid
+}
+
+// generateRecoveryCodes returns n codes formatted as xxxxx-xxxxx and their hashes
+func generateRecoveryCodes(n int) ([]string, map[string]struct{}, error) {
+	codes := make([]string, 0, n)
+	hashes := make(map[string]struct{}, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("could not generate recovery code")
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes[hashRecoveryCode(code)] = struct{}{}
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+// newTOTPBank returns a bank with the account "test" whose clock stands at
+// start
+func newTOTPBank(stepUp int) *bank {
+	service := &bank{
+		clock: clock.NewFake(start),
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		totps: newTOTPMap(config.TOTPConfig{StepUpAmount: stepUp}),
+	}
+	service.users.data["test"] = proto.User{
+		Account:  "test",
+		Balance:  100,
+		Password: "test-pwd",
+		Name:     "test-user",
+	}
+	return service
+}
+
+func currentCode(t *testing.T, secret string, offset int64) string {
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(start)+offset)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return code
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	service := newTOTPBank(0)
+
+	_, err := service.EnrollTOTP(ctx, "t")
+	if !errors.Is(err, ErrAccountNotExist) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountNotExist, err)
+	}
+
+	enrollment, err := service.EnrollTOTP(ctx, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(enrollment.RecoveryCodes) != RecoveryCodeCount {
+		t.Fatalf("Expected recovery codes: %v, got: %v", RecoveryCodeCount, len(enrollment.RecoveryCodes))
+	}
+
+	// the login does not need a code until the enrollment is confirmed
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, 10))
+	if !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPInvalid, err)
+	}
+	if err := service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, -1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = service.EnrollTOTP(ctx, "test")
+	if !errors.Is(err, ErrTOTPEnrolled) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPEnrolled, err)
+	}
+}
+
+func TestGetNonceWithTOTP(t *testing.T) {
+	service := newTOTPBank(0)
+	enrollment, err := service.EnrollTOTP(ctx, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, -1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = service.GetNonce(ctx, "test", "test-pwd", "")
+	if !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPRequired, err)
+	}
+
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", currentCode(t, enrollment.Secret, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// a code can not be replayed
+	_, err = service.GetNonce(ctx, "test", "test-pwd", currentCode(t, enrollment.Secret, 0))
+	if !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPInvalid, err)
+	}
+
+	// a recovery code works once
+	recovery := enrollment.RecoveryCodes[0]
+	if _, err := service.GetNonce(ctx, "test", "test-pwd", recovery); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = service.GetNonce(ctx, "test", "test-pwd", recovery)
+	if !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPInvalid, err)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	service := newTOTPBank(50)
+
+	// accounts without totp are not challenged
+	if err := service.StepUp(ctx, "test", 80, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	enrollment, err := service.EnrollTOTP(ctx, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, -1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := service.StepUp(ctx, "test", 50, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = service.StepUp(ctx, "test", 80, "")
+	if !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPRequired, err)
+	}
+	if err := service.StepUp(ctx, "test", 80, currentCode(t, enrollment.Secret, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	service := newTOTPBank(50)
+	clk := service.clock.(*clock.Fake)
+	service.guard = newLoginGuard(config.LockoutConfig{
+		MaxAttempts:  3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Second,
+		LockDuration: time.Hour,
+	}, nil)
+
+	enrollment, err := service.EnrollTOTP(ctx, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// a wrong confirmation counts, the backoff refuses the next one
+	err = service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, 10))
+	if !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTOTPInvalid, err)
+	}
+	err = service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, 0))
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTooManyAttempts, err)
+	}
+	clk.Advance(time.Second)
+	if err := service.ConfirmTOTP(ctx, "test", currentCode(t, enrollment.Secret, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the step up codes of a stolen token can not be guessed without end
+	for i := 0; i < 2; i++ {
+		clk.Advance(time.Second)
+		err := service.StepUp(ctx, "test", 80, "wrong-code")
+		if !errors.Is(err, ErrTOTPInvalid) {
+			t.Fatalf("Expected error: %v, got: %v", ErrTOTPInvalid, err)
+		}
+	}
+	clk.Advance(time.Second)
+	err = service.StepUp(ctx, "test", 80, currentCode(t, enrollment.Secret, 1))
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+	_, err = service.GetNonce(ctx, "test", "test-pwd", enrollment.RecoveryCodes[0])
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountLocked, err)
+	}
+}
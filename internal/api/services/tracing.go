@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the bank
+const tracerName = "github.com/0x726f6f6b6965/bank/internal/api/services"
+
+// tracedBank starts a span around every method of the bank, the span is a
+// child of the span of ctx so the handler and the service share a trace.
+// The secrets of the arguments never become attributes.
+type tracedBank struct {
+	next   BankInterface
+	tracer trace.Tracer
+}
+
+// WithTracing returns b with a span around every method, the spans are
+// recorded once a tracer provider is installed
+func WithTracing(b BankInterface) BankInterface {
+	return &tracedBank{next: b, tracer: otel.Tracer(tracerName)}
+}
+
+func (t *tracedBank) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "bank."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func accountAttr(account string) attribute.KeyValue {
+	return attribute.String("bank.account", account)
+}
+
+func transactionAttrs(tx proto.Transaction) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("bank.from", tx.From),
+		attribute.String("bank.to", tx.To),
+		attribute.Int("bank.amount", tx.Amount),
+	}
+}
+
+func (t *tracedBank) CreateAccount(ctx context.Context, user proto.User) (*proto.User, error) {
+	ctx, span := t.startSpan(ctx, "CreateAccount", accountAttr(user.Account))
+	resp, err := t.next.CreateAccount(ctx, user)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) Deposit(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	ctx, span := t.startSpan(ctx, "Deposit", transactionAttrs(tx)...)
+	resp, newNonce, err := t.next.Deposit(ctx, tx, nonce)
+	if resp != nil {
+		span.SetAttributes(attribute.Int64("bank.transaction", int64(resp.ID)))
+	}
+	endSpan(span, err)
+	return resp, newNonce, err
+}
+
+func (t *tracedBank) Withdraw(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	ctx, span := t.startSpan(ctx, "Withdraw", transactionAttrs(tx)...)
+	resp, newNonce, err := t.next.Withdraw(ctx, tx, nonce)
+	if resp != nil {
+		span.SetAttributes(attribute.Int64("bank.transaction", int64(resp.ID)))
+	}
+	endSpan(span, err)
+	return resp, newNonce, err
+}
+
+func (t *tracedBank) Transaction(ctx context.Context, tx proto.Transaction, nonce string) (*proto.Transaction, string, error) {
+	ctx, span := t.startSpan(ctx, "Transaction", transactionAttrs(tx)...)
+	resp, newNonce, err := t.next.Transaction(ctx, tx, nonce)
+	if resp != nil {
+		span.SetAttributes(attribute.Int64("bank.transaction", int64(resp.ID)))
+	}
+	endSpan(span, err)
+	return resp, newNonce, err
+}
+
+func (t *tracedBank) GetNonce(ctx context.Context, account, pwd, code string) (string, error) {
+	ctx, span := t.startSpan(ctx, "GetNonce", accountAttr(account))
+	resp, err := t.next.GetNonce(ctx, account, pwd, code)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) GetBalance(ctx context.Context, account string) (int, error) {
+	ctx, span := t.startSpan(ctx, "GetBalance", accountAttr(account))
+	resp, err := t.next.GetBalance(ctx, account)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) GetTransactions(ctx context.Context, account string) ([]proto.Transaction, error) {
+	ctx, span := t.startSpan(ctx, "GetTransactions", accountAttr(account))
+	resp, err := t.next.GetTransactions(ctx, account)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) GetTransaction(ctx context.Context, account string, id uint64) (*proto.Transaction, error) {
+	ctx, span := t.startSpan(ctx, "GetTransaction", accountAttr(account), attribute.Int64("bank.transaction", int64(id)))
+	resp, err := t.next.GetTransaction(ctx, account, id)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) Subscribe(ctx context.Context, account string, after uint64) (<-chan proto.Event, error) {
+	ctx, span := t.startSpan(ctx, "Subscribe", accountAttr(account), attribute.Int64("bank.after", int64(after)))
+	resp, err := t.next.Subscribe(ctx, account, after)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) CreateWebhook(ctx context.Context, account string, req proto.CreateWebhookRequest) (*proto.Webhook, error) {
+	ctx, span := t.startSpan(ctx, "CreateWebhook", accountAttr(account))
+	resp, err := t.next.CreateWebhook(ctx, account, req)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) GetWebhooks(ctx context.Context, account string) ([]proto.Webhook, error) {
+	ctx, span := t.startSpan(ctx, "GetWebhooks", accountAttr(account))
+	resp, err := t.next.GetWebhooks(ctx, account)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) DeleteWebhook(ctx context.Context, account, id string) error {
+	ctx, span := t.startSpan(ctx, "DeleteWebhook", accountAttr(account), attribute.String("bank.webhook", id))
+	err := t.next.DeleteWebhook(ctx, account, id)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) GetDeliveries(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error) {
+	ctx, span := t.startSpan(ctx, "GetDeliveries", accountAttr(account), attribute.String("bank.webhook", id))
+	resp, err := t.next.GetDeliveries(ctx, account, id)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) GetDeadLetters(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error) {
+	ctx, span := t.startSpan(ctx, "GetDeadLetters", accountAttr(account), attribute.String("bank.webhook", id))
+	resp, err := t.next.GetDeadLetters(ctx, account, id)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) RetryDeadLetter(ctx context.Context, account, id, deliveryID string) error {
+	ctx, span := t.startSpan(ctx, "RetryDeadLetter", accountAttr(account),
+		attribute.String("bank.webhook", id), attribute.String("bank.delivery", deliveryID))
+	err := t.next.RetryDeadLetter(ctx, account, id, deliveryID)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) UnlockAccount(ctx context.Context, account string) error {
+	ctx, span := t.startSpan(ctx, "UnlockAccount", accountAttr(account))
+	err := t.next.UnlockAccount(ctx, account)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) EnrollTOTP(ctx context.Context, account string) (*proto.TOTPEnrollment, error) {
+	ctx, span := t.startSpan(ctx, "EnrollTOTP", accountAttr(account))
+	resp, err := t.next.EnrollTOTP(ctx, account)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) ConfirmTOTP(ctx context.Context, account, code string) error {
+	ctx, span := t.startSpan(ctx, "ConfirmTOTP", accountAttr(account))
+	err := t.next.ConfirmTOTP(ctx, account, code)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) StepUp(ctx context.Context, account string, amount int, code string) error {
+	ctx, span := t.startSpan(ctx, "StepUp", accountAttr(account), attribute.Int("bank.amount", amount))
+	err := t.next.StepUp(ctx, account, amount, code)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) ChangePassword(ctx context.Context, account, oldPwd, newPwd string) error {
+	ctx, span := t.startSpan(ctx, "ChangePassword", accountAttr(account))
+	err := t.next.ChangePassword(ctx, account, oldPwd, newPwd)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) RequestPasswordReset(ctx context.Context, account string) error {
+	ctx, span := t.startSpan(ctx, "RequestPasswordReset", accountAttr(account))
+	err := t.next.RequestPasswordReset(ctx, account)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) ResetPassword(ctx context.Context, token, newPwd string) error {
+	ctx, span := t.startSpan(ctx, "ResetPassword")
+	err := t.next.ResetPassword(ctx, token, newPwd)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) TrackToken(ctx context.Context, token *proto.UserToken) error {
+	ctx, span := t.startSpan(ctx, "TrackToken", accountAttr(tokenAccount(token)))
+	err := t.next.TrackToken(ctx, token)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) VerifyToken(ctx context.Context, token *proto.UserToken) error {
+	ctx, span := t.startSpan(ctx, "VerifyToken", accountAttr(tokenAccount(token)))
+	err := t.next.VerifyToken(ctx, token)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) Logout(ctx context.Context, token *proto.UserToken) error {
+	ctx, span := t.startSpan(ctx, "Logout", accountAttr(tokenAccount(token)))
+	err := t.next.Logout(ctx, token)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) LogoutAll(ctx context.Context, account string) error {
+	ctx, span := t.startSpan(ctx, "LogoutAll", accountAttr(account))
+	err := t.next.LogoutAll(ctx, account)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBank) GetSessions(ctx context.Context, token *proto.UserToken) ([]proto.Session, error) {
+	ctx, span := t.startSpan(ctx, "GetSessions", accountAttr(tokenAccount(token)))
+	resp, err := t.next.GetSessions(ctx, token)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t *tracedBank) RevokeSession(ctx context.Context, account, id string) error {
+	ctx, span := t.startSpan(ctx, "RevokeSession", accountAttr(account), attribute.String("bank.session", id))
+	err := t.next.RevokeSession(ctx, account, id)
+	endSpan(span, err)
+	return err
+}
+
+// Ready, Drain and Close are not traced, the probes would flood the traces
+
+func (t *tracedBank) Ready(ctx context.Context) error {
+	return t.next.Ready(ctx)
+}
+
+func (t *tracedBank) Drain(ctx context.Context) {
+	t.next.Drain(ctx)
+}
+
+func (t *tracedBank) Close(ctx context.Context) error {
+	return t.next.Close(ctx)
+}
+
+func tokenAccount(token *proto.UserToken) string {
+	if token == nil {
+		return ""
+	}
+	return token.Account
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedBank(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(ctx)
+
+	service := &tracedBank{next: newWebhookBank(config.WebhooksConfig{}), tracer: provider.Tracer(tracerName)}
+	parentCtx, parent := provider.Tracer("test").Start(ctx, "request")
+	_, nonce, err := service.Deposit(parentCtx, proto.Transaction{To: "test", Amount: 10}, "test-nonce")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	_, _, err = service.Withdraw(parentCtx, proto.Transaction{From: "test", Amount: 1000}, nonce)
+	if !errors.Is(err, ErrBalanceNotEnough) {
+		t.Fatalf("Expected error: %v, got: %v", ErrBalanceNotEnough, err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected spans: %v, got: %v", 3, len(spans))
+	}
+	deposit, withdraw := spans[0], spans[1]
+	if deposit.Name() != "bank.Deposit" || withdraw.Name() != "bank.Withdraw" {
+		t.Fatalf("Expected spans: %v, got: %v %v", "bank.Deposit bank.Withdraw", deposit.Name(), withdraw.Name())
+	}
+	for _, span := range []sdktrace.ReadOnlySpan{deposit, withdraw} {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("Expected %s to be a child of the request", span.Name())
+		}
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range deposit.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if attrs["bank.to"].AsString() != "test" || attrs["bank.amount"].AsInt64() != 10 || attrs["bank.transaction"].AsInt64() != 1 {
+		t.Fatalf("Expected the attributes of the deposit, got: %v", deposit.Attributes())
+	}
+	if deposit.Status().Code != codes.Unset || withdraw.Status().Code != codes.Error {
+		t.Fatalf("Expected the withdraw to fail, got: %v %v", deposit.Status(), withdraw.Status())
+	}
+}
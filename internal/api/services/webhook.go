@@ -0,0 +1,524 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+var (
+	DefaultWebhookAttempts    = 5
+	DefaultWebhookBaseDelay   = time.Second
+	DefaultWebhookMaxDelay    = 5 * time.Minute
+	DefaultWebhookTimeout     = 10 * time.Second
+	DefaultWebhooksPerAccount = 10
+	// WebhookLogSize is the number of deliveries kept per webhook
+	WebhookLogSize   = 100
+	WebhookSecretLen = 32
+
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// webhookEvents are the event types a webhook can subscribe to
+var webhookEvents = map[string]bool{
+	proto.EventCredited: true,
+	proto.EventDebited:  true,
+}
+
+// webhooks delivers the events of the accounts to their webhooks, a failed
+// delivery is retried with an exponential backoff and ends up in the
+// dead-letter list once every attempt failed
+type webhooks struct {
+	sync.Mutex
+	cfg    config.WebhooksConfig
+	clock  clock.Clock
+	ids    ids.Generator
+	client *http.Client
+	// hooks - webhook ID -> webhook
+	hooks map[string]*proto.Webhook
+	// deliveries - webhook ID -> deliveries, the oldest first
+	deliveries map[string][]*proto.WebhookDelivery
+	// dead - webhook ID -> dead letters, the oldest first, they are kept
+	// until they are retried so the trimming of the log does not lose them
+	dead map[string][]*proto.WebhookDelivery
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newWebhooks returns the webhooks of cfg, the attempts are timed and
+// retried by clk and the deliveries are named by gen
+func newWebhooks(cfg config.WebhooksConfig, clk clock.Clock, gen ids.Generator) *webhooks {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultWebhookAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultWebhookBaseDelay
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = max(DefaultWebhookMaxDelay, cfg.BaseDelay)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebhookTimeout
+	}
+	if cfg.MaxPerAccount <= 0 {
+		cfg.MaxPerAccount = DefaultWebhooksPerAccount
+	}
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		// the address is checked again at every dial, a name resolving to
+		// a public address at the registration can resolve to an internal
+		// one later
+		dialer.Control = checkDial
+	}
+	return &webhooks{
+		cfg:   cfg,
+		clock: clock.Or(clk),
+		ids:   ids.Or(gen),
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// no proxy, the dialed address is the one of the webhook
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: cfg.Timeout,
+			},
+		},
+		hooks:      make(map[string]*proto.Webhook),
+		deliveries: make(map[string][]*proto.WebhookDelivery),
+		dead:       make(map[string][]*proto.WebhookDelivery),
+		stop:       make(chan struct{}),
+	}
+}
+
+// publicIP reports whether a webhook may target ip
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 0 {
+		// 0.0.0.0/8 reaches this host
+		return false
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// checkDial refuses the connections of the deliveries to non-public
+// addresses, it is the net.Dialer.Control of the webhook client
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s: %w", host, ErrWebhookTarget)
+	}
+	return nil
+}
+
+// checkTarget resolves the host of a webhook, every address must be public
+// unless the private networks are allowed
+func (w *webhooks) checkTarget(ctx context.Context, host string) error {
+	if w.cfg.AllowPrivateNetworks {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ErrWebhookTarget
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrWebhookTarget
+		}
+	}
+	return nil
+}
+
+// Publish dispatches the event of an outbox message, the deliveries are
+// retried on their own so the message is published once they started
+func (w *webhooks) Publish(ctx context.Context, msg proto.OutboxMessage) error {
+	w.Dispatch(msg.Event)
+	return nil
+}
+
+// Dispatch starts a delivery of the event to every webhook of its account
+// which subscribed to its type
+func (w *webhooks) Dispatch(event proto.Event) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	now := w.clock.Now()
+	for _, hook := range w.hooks {
+		if hook.Account != event.Account || !subscribed(hook, event.Type) {
+			continue
+		}
+		d := &proto.WebhookDelivery{
+			ID:        w.ids.NewID(),
+			WebhookID: hook.ID,
+			Event:     event,
+			State:     proto.DeliveryPending,
+			Attempts:  []proto.WebhookAttempt{},
+			CreatedAt: now.Unix(),
+			UpdatedAt: now.Unix(),
+		}
+		w.add(d)
+		w.start(*hook, d)
+	}
+}
+
+// Close stops the retries, the undelivered events stay pending
+func (w *webhooks) Close() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	w.Unlock()
+	w.wg.Wait()
+}
+
+// start runs the delivery in the background, the caller holds the lock
+func (w *webhooks) start(hook proto.Webhook, d *proto.WebhookDelivery) {
+	select {
+	case <-w.stop:
+		return
+	default:
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.deliver(hook, d)
+	}()
+}
+
+func (w *webhooks) deliver(hook proto.Webhook, d *proto.WebhookDelivery) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		result := w.send(hook, d, body)
+
+		w.Lock()
+		if _, ok := w.hooks[hook.ID]; !ok {
+			// the webhook was deleted
+			w.Unlock()
+			return
+		}
+		d.Attempts = append(d.Attempts, result)
+		d.UpdatedAt = result.CreatedAt
+		switch {
+		case result.StatusCode >= 200 && result.StatusCode < 300:
+			d.State = proto.DeliveryDelivered
+		case attempt >= w.cfg.MaxAttempts:
+			d.State = proto.DeliveryDead
+			w.dead[hook.ID] = append(w.dead[hook.ID], d)
+		}
+		state := d.State
+		w.Unlock()
+		switch state {
+		case proto.DeliveryDelivered:
+			return
+		case proto.DeliveryDead:
+			slog.Warn("webhook delivery dead",
+				"webhook", hook.ID,
+				"delivery", d.ID,
+				"attempts", attempt,
+				"error", result.Error,
+			)
+			return
+		}
+		slog.Debug("webhook delivery failed",
+			"webhook", hook.ID,
+			"delivery", d.ID,
+			"attempt", attempt,
+			"error", result.Error,
+		)
+
+		if !clock.Sleep(w.clock, w.backoff(attempt), w.stop) {
+			return
+		}
+	}
+}
+
+// send posts the event once, the body is signed with the secret of the
+// webhook over "<timestamp>.<body>"
+func (w *webhooks) send(hook proto.Webhook, d *proto.WebhookDelivery, body []byte) proto.WebhookAttempt {
+	start := w.clock.Now()
+	result := proto.WebhookAttempt{CreatedAt: start.Unix()}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event.Type)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	result.Duration = w.clock.Now().Sub(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return result
+}
+
+// backoff returns the wait after the nth failed attempt
+func (w *webhooks) backoff(n int) time.Duration {
+	delay := w.cfg.BaseDelay
+	for i := 1; i < n && delay < w.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.cfg.MaxDelay)
+}
+
+// add appends the delivery to the log of its webhook, the caller holds the lock
+func (w *webhooks) add(d *proto.WebhookDelivery) {
+	entries := append(w.deliveries[d.WebhookID], d)
+	if len(entries) > WebhookLogSize {
+		entries = entries[len(entries)-WebhookLogSize:]
+	}
+	w.deliveries[d.WebhookID] = entries
+}
+
+// hook returns the webhook if it belongs to the account, the caller holds the lock
+func (w *webhooks) hook(account, id string) (*proto.Webhook, bool) {
+	hook, ok := w.hooks[id]
+	if !ok || hook.Account != account {
+		return nil, false
+	}
+	return hook, true
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" with the secret,
+// receivers compare it with the X-Webhook-Signature header
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(hook *proto.Webhook, eventType string) bool {
+	for _, e := range hook.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bank) CreateWebhook(ctx context.Context, account string, req proto.CreateWebhookRequest) (*proto.Webhook, error) {
+	if utils.IsEmpty(account) {
+		return nil, ErrEmptyAccount
+	}
+	if b.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrWebhookURL
+	}
+	if len(req.Events) == 0 {
+		return nil, ErrWebhookEvents
+	}
+	for _, e := range req.Events {
+		if !webhookEvents[e] {
+			return nil, ErrWebhookEvents
+		}
+	}
+	secret := req.Secret
+	if utils.IsEmpty(secret) {
+		if secret, err = utils.GenerateNonce(WebhookSecretLen); err != nil {
+			return nil, err
+		}
+	}
+
+	b.users.RLock()
+	_, ok := b.users.data[account]
+	b.users.RUnlock()
+	if !ok {
+		return nil, ErrAccountNotExist
+	}
+
+	w := b.webhooks
+	if err := w.checkTarget(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	w.Lock()
+	defer w.Unlock()
+	n := 0
+	for _, hook := range w.hooks {
+		if hook.Account == account {
+			n++
+		}
+	}
+	if n >= w.cfg.MaxPerAccount {
+		return nil, ErrTooManyWebhooks
+	}
+	hook := &proto.Webhook{
+		ID:        b.newID(),
+		Account:   account,
+		URL:       u.String(),
+		Events:    append([]string(nil), req.Events...),
+		Secret:    secret,
+		CreatedAt: b.now().Unix(),
+	}
+	w.hooks[hook.ID] = hook
+	resp := *hook
+	return &resp, nil
+}
+
+// GetWebhooks returns the webhooks of the account without their secrets,
+// the oldest first
+func (b *bank) GetWebhooks(ctx context.Context, account string) ([]proto.Webhook, error) {
+	resp := []proto.Webhook{}
+	if utils.IsEmpty(account) {
+		return resp, ErrEmptyAccount
+	}
+	if b.webhooks == nil {
+		return resp, nil
+	}
+	w := b.webhooks
+	w.Lock()
+	defer w.Unlock()
+	for _, hook := range w.hooks {
+		if hook.Account == account {
+			h := *hook
+			h.Secret = ""
+			resp = append(resp, h)
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].CreatedAt == resp[j].CreatedAt {
+			return resp[i].ID < resp[j].ID
+		}
+		return resp[i].CreatedAt < resp[j].CreatedAt
+	})
+	return resp, nil
+}
+
+func (b *bank) DeleteWebhook(ctx context.Context, account, id string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+	if b.webhooks == nil {
+		return ErrWebhookNotFound
+	}
+	w := b.webhooks
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.hook(account, id); !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.hooks, id)
+	delete(w.deliveries, id)
+	delete(w.dead, id)
+	return nil
+}
+
+// GetDeliveries returns the delivery log of the webhook, the newest first
+func (b *bank) GetDeliveries(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error) {
+	return b.deliveries(account, id, func(w *webhooks) []*proto.WebhookDelivery {
+		return w.deliveries[id]
+	})
+}
+
+// GetDeadLetters returns the deliveries of the webhook which failed every
+// attempt, the newest first
+func (b *bank) GetDeadLetters(ctx context.Context, account, id string) ([]proto.WebhookDelivery, error) {
+	return b.deliveries(account, id, func(w *webhooks) []*proto.WebhookDelivery {
+		return w.dead[id]
+	})
+}
+
+// deliveries returns the copies of the list of the webhook, the newest first
+func (b *bank) deliveries(account, id string, list func(*webhooks) []*proto.WebhookDelivery) ([]proto.WebhookDelivery, error) {
+	resp := []proto.WebhookDelivery{}
+	if utils.IsEmpty(account) {
+		return resp, ErrEmptyAccount
+	}
+	if b.webhooks == nil {
+		return resp, ErrWebhookNotFound
+	}
+	w := b.webhooks
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.hook(account, id); !ok {
+		return resp, ErrWebhookNotFound
+	}
+	entries := list(w)
+	for i := len(entries) - 1; i >= 0; i-- {
+		d := *entries[i]
+		d.Attempts = append([]proto.WebhookAttempt{}, d.Attempts...)
+		resp = append(resp, d)
+	}
+	return resp, nil
+}
+
+// RetryDeadLetter delivers a dead letter again with a fresh set of attempts
+func (b *bank) RetryDeadLetter(ctx context.Context, account, id, deliveryID string) error {
+	if utils.IsEmpty(account) {
+		return ErrEmptyAccount
+	}
+	if b.webhooks == nil {
+		return ErrWebhookNotFound
+	}
+	w := b.webhooks
+	w.Lock()
+	defer w.Unlock()
+	hook, ok := w.hook(account, id)
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	dead := w.dead[id]
+	for i, d := range dead {
+		if d.ID == deliveryID {
+			w.dead[id] = append(dead[:i:i], dead[i+1:]...)
+			d.State = proto.DeliveryPending
+			d.UpdatedAt = b.now().Unix()
+			w.start(*hook, d)
+			return nil
+		}
+	}
+	return ErrDeliveryNotFound
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/ids"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+)
+
+// newWebhookBank returns a bank with the accounts "test" and "test2", the
+// bank and its webhooks tell the time with a fake clock stopped at start.
+// Its relay is not started, the tests drain it.
+func newWebhookBank(cfg config.WebhooksConfig) *bank {
+	clk := clock.NewFake(start)
+	service := &bank{
+		clock: clk,
+		users: &userMap{
+			data: make(map[string]proto.User),
+		},
+		txs: &txMap{
+			data: make(map[uint64]proto.Transaction),
+		},
+		count:    1,
+		search:   NewSearch(),
+		events:   newEventBus(config.EventsConfig{}),
+		webhooks: newWebhooks(cfg, clk, ids.NewSequence()),
+		outbox:   newOutbox(),
+	}
+	service.relay = newRelay(config.OutboxConfig{}, service.outbox, clk, service.events, service.webhooks)
+	service.users.data["test"] = proto.User{Account: "test", Balance: 100, Nonce: "test-nonce"}
+	service.users.data["test2"] = proto.User{Account: "test2", Balance: 10, Nonce: "test2-nonce"}
+	return service
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	ch := make(chan received, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ch <- received{r.Header, body}
+	}))
+	defer receiver.Close()
+
+	service := newWebhookBank(config.WebhooksConfig{AllowPrivateNetworks: true})
+	defer service.webhooks.Close()
+
+	hook, err := service.CreateWebhook(ctx, "test2", proto.CreateWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{proto.EventCredited},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hook.Secret == "" {
+		t.Fatalf("Expected a generated secret")
+	}
+
+	if _, _, err := service.Transaction(ctx, proto.Transaction{From: "test", To: "test2", Amount: 30}, "test-nonce"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service.relay.drain()
+
+	var got received
+	select {
+	case got = <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a delivery")
+	}
+	if got.header.Get(EventHeader) != proto.EventCredited {
+		t.Fatalf("Expected event: %v, got: %v", proto.EventCredited, got.header.Get(EventHeader))
+	}
+	signature := "sha256=" + Sign(hook.Secret, got.header.Get(TimestampHeader), got.body)
+	if got.header.Get(SignatureHeader) != signature {
+		t.Fatalf("Expected signature: %v, got: %v", signature, got.header.Get(SignatureHeader))
+	}
+
+	service.webhooks.wg.Wait()
+	deliveries, _ := service.GetDeliveries(ctx, "test2", hook.ID)
+	if len(deliveries) != 1 || deliveries[0].State != proto.DeliveryDelivered {
+		t.Fatalf("Expected a delivered event, got: %v", deliveries)
+	}
+	if len(deliveries[0].Attempts) != 1 || deliveries[0].Attempts[0].StatusCode != http.StatusOK {
+		t.Fatalf("Expected a single successful attempt, got: %v", deliveries[0].Attempts)
+	}
+	if deliveries[0].Event.Balance != 40 {
+		t.Fatalf("Expected balance: %v, got: %v", 40, deliveries[0].Event.Balance)
+	}
+
+	// the list hides the secret
+	hooks, _ := service.GetWebhooks(ctx, "test2")
+	if len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Fatalf("Expected the webhook without its secret, got: %v", hooks)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	service := newWebhookBank(config.WebhooksConfig{
+		MaxAttempts:          3,
+		BaseDelay:            time.Minute,
+		MaxDelay:             time.Hour,
+		AllowPrivateNetworks: true,
+	})
+	defer service.webhooks.Close()
+	clk := service.clock.(*clock.Fake)
+
+	hook, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{proto.EventDebited},
+		Secret: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 10}, "test-nonce"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service.relay.drain()
+
+	// the retries wait one and then two minutes of the clock
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+	service.webhooks.wg.Wait()
+
+	dead, _ := service.GetDeadLetters(ctx, "test", hook.ID)
+	if len(dead) != 1 || len(dead[0].Attempts) != 3 || calls.Load() != 3 {
+		t.Fatalf("Expected a dead letter after %v attempts, got: %v", 3, dead)
+	}
+	for i, offset := range []time.Duration{0, time.Minute, 3 * time.Minute} {
+		if at := start.Add(offset).Unix(); dead[0].Attempts[i].CreatedAt != at {
+			t.Fatalf("Expected attempt %d at: %v, got: %v", i+1, at, dead[0].Attempts[i].CreatedAt)
+		}
+	}
+
+	// only the owner retries
+	err = service.RetryDeadLetter(ctx, "test2", hook.ID, dead[0].ID)
+	if !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrWebhookNotFound, err)
+	}
+
+	failing.Store(false)
+	if err := service.RetryDeadLetter(ctx, "test", hook.ID, dead[0].ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service.webhooks.wg.Wait()
+	deliveries, _ := service.GetDeliveries(ctx, "test", hook.ID)
+	if deliveries[0].State != proto.DeliveryDelivered {
+		t.Fatalf("Expected state: %v, got: %v", proto.DeliveryDelivered, deliveries[0].State)
+	}
+	err = service.RetryDeadLetter(ctx, "test", hook.ID, dead[0].ID)
+	if !errors.Is(err, ErrDeliveryNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrDeliveryNotFound, err)
+	}
+}
+
+func TestCreateWebhookWithError(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{MaxPerAccount: 1})
+	defer service.webhooks.Close()
+
+	_, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{URL: "ftp://example.com", Events: []string{proto.EventCredited}})
+	if !errors.Is(err, ErrWebhookURL) {
+		t.Fatalf("Expected error: %v, got: %v", ErrWebhookURL, err)
+	}
+	_, err = service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{URL: "https://203.0.113.10", Events: []string{"t"}})
+	if !errors.Is(err, ErrWebhookEvents) {
+		t.Fatalf("Expected error: %v, got: %v", ErrWebhookEvents, err)
+	}
+	_, err = service.CreateWebhook(ctx, "t", proto.CreateWebhookRequest{URL: "https://203.0.113.10", Events: []string{proto.EventCredited}})
+	if !errors.Is(err, ErrAccountNotExist) {
+		t.Fatalf("Expected error: %v, got: %v", ErrAccountNotExist, err)
+	}
+
+	hook, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{URL: "https://203.0.113.10", Events: []string{proto.EventCredited}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{URL: "https://203.0.113.10", Events: []string{proto.EventCredited}})
+	if !errors.Is(err, ErrTooManyWebhooks) {
+		t.Fatalf("Expected error: %v, got: %v", ErrTooManyWebhooks, err)
+	}
+
+	err = service.DeleteWebhook(ctx, "test2", hook.ID)
+	if !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrWebhookNotFound, err)
+	}
+	if err := service.DeleteWebhook(ctx, "test", hook.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = service.GetDeliveries(ctx, "test", hook.ID)
+	if !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("Expected error: %v, got: %v", ErrWebhookNotFound, err)
+	}
+}
+
+func TestWebhookTarget(t *testing.T) {
+	service := newWebhookBank(config.WebhooksConfig{MaxPerAccount: 10})
+	defer service.webhooks.Close()
+
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://127.0.0.1", ErrWebhookTarget},
+		{"http://169.254.169.254/latest/meta-data", ErrWebhookTarget},
+		{"https://10.0.0.1", ErrWebhookTarget},
+		{"https://192.168.1.1:8443", ErrWebhookTarget},
+		{"http://localhost:8080", ErrWebhookTarget},
+		{"https://[::1]", ErrWebhookTarget},
+		{"https://[fe80::1]", ErrWebhookTarget},
+		{"https://0.0.0.0", ErrWebhookTarget},
+		{"https://203.0.113.10", nil},
+	}
+	for _, tt := range tests {
+		_, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{URL: tt.url, Events: []string{proto.EventCredited}})
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("Expected error for %s: %v, got: %v", tt.url, tt.err, err)
+		}
+	}
+}
+
+func TestWebhookTargetAtDial(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	service := newWebhookBank(config.WebhooksConfig{MaxAttempts: 1})
+	defer service.webhooks.Close()
+
+	hook, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{
+		URL:    "https://203.0.113.10",
+		Events: []string{proto.EventDebited},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the name of the webhook resolves to an internal address now
+	service.webhooks.Lock()
+	service.webhooks.hooks[hook.ID].URL = receiver.URL
+	service.webhooks.Unlock()
+
+	if _, _, err := service.Withdraw(ctx, proto.Transaction{From: "test", Amount: 10}, "test-nonce"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service.relay.drain()
+	service.webhooks.wg.Wait()
+	dead, _ := service.GetDeadLetters(ctx, "test", hook.ID)
+	if len(dead) != 1 || calls.Load() != 0 || !strings.Contains(dead[0].Attempts[0].Error, ErrWebhookTarget.Error()) {
+		t.Fatalf("Expected the dial to be refused, got: %v", dead[0].Attempts)
+	}
+}
+
+func TestWebhookDeadLetterKept(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	service := newWebhookBank(config.WebhooksConfig{MaxAttempts: 1, AllowPrivateNetworks: true})
+	defer service.webhooks.Close()
+
+	hook, err := service.CreateWebhook(ctx, "test", proto.CreateWebhookRequest{
+		URL:    receiver.URL,
+		Events: []string{proto.EventDebited},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dispatch := func() {
+		service.webhooks.Dispatch(proto.Event{Account: "test", Type: proto.EventDebited})
+	}
+	dispatch()
+	service.webhooks.wg.Wait()
+
+	// the dead letter outlives the trimming of the log
+	failing.Store(false)
+	for i := 0; i < WebhookLogSize; i++ {
+		dispatch()
+	}
+	service.webhooks.wg.Wait()
+	deliveries, _ := service.GetDeliveries(ctx, "test", hook.ID)
+	for _, d := range deliveries {
+		if d.State != proto.DeliveryDelivered {
+			t.Fatalf("Expected state: %v, got: %v", proto.DeliveryDelivered, d.State)
+		}
+	}
+	dead, _ := service.GetDeadLetters(ctx, "test", hook.ID)
+	if len(dead) != 1 {
+		t.Fatalf("Expected dead letters: %v, got: %v", 1, len(dead))
+	}
+
+	if err := service.RetryDeadLetter(ctx, "test", hook.ID, dead[0].ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dead, _ = service.GetDeadLetters(ctx, "test", hook.ID)
+	if len(dead) != 0 {
+		t.Fatalf("Expected no dead letters, got: %v", dead)
+	}
+}
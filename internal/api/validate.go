@@ -0,0 +1,45 @@
+package api
+
+import (
+	"github.com/0x726f6f6b6965/bank/internal/api/apierr"
+	"github.com/0x726f6f6b6965/bank/internal/api/services"
+	"github.com/0x726f6f6b6965/bank/internal/policy"
+	"github.com/0x726f6f6b6965/bank/internal/proto"
+	"github.com/0x726f6f6b6965/bank/internal/utils"
+)
+
+// ValidateTransaction lists every field of the request which does not fit its action
+func ValidateTransaction(param proto.TransactionRequest) error {
+	errs := &policy.ValidationError{}
+	switch param.Action {
+	case proto.TransactionActionDeposit:
+		if !utils.IsEmpty(param.From) {
+			errs.Add("from", services.ErrFromAccount)
+		}
+		if utils.IsEmpty(param.To) {
+			errs.Add("to", services.ErrToAccount)
+		}
+	case proto.TransactionActionWithdraw:
+		if utils.IsEmpty(param.From) {
+			errs.Add("from", services.ErrFromAccount)
+		}
+		if !utils.IsEmpty(param.To) {
+			errs.Add("to", services.ErrToAccount)
+		}
+	case proto.TransactionActionTransfer:
+		if utils.IsEmpty(param.From) {
+			errs.Add("from", services.ErrFromAccount)
+		}
+		if utils.IsEmpty(param.To) {
+			errs.Add("to", services.ErrToAccount)
+		} else if param.From == param.To {
+			errs.Add("to", services.ErrSameAccount)
+		}
+	default:
+		errs.Add("action", apierr.ErrInvalidAction)
+	}
+	if param.Amount <= 0 {
+		errs.Add("amount", services.ErrNegativeBalance)
+	}
+	return errs.Err()
+}
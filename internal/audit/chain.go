@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Genesis is the previous hash of the first entry
+	Genesis = strings.Repeat("0", sha256.Size*2)
+
+	ErrBrokenChain = errors.New("audit chain is broken")
+	ErrHead        = errors.New("audit chain does not end at the expected head")
+	ErrClosed      = errors.New("audit chain is closed")
+)
+
+// Entry - a link of the audit chain, Hash covers the sequence number, the
+// hash of the previous entry and the event exactly as it is stored
+type Entry struct {
+	Seq      uint64          `json:"seq"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+	Event    json.RawMessage `json:"event"`
+}
+
+// Hash returns the hex SHA-256 of "<seq>\n<prev>\n<event>"
+func Hash(seq uint64, prev string, event []byte) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatUint(seq, 10)))
+	h.Write([]byte("\n"))
+	h.Write([]byte(prev))
+	h.Write([]byte("\n"))
+	h.Write(event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Chain appends the entries as JSON lines to a writer, every entry carries
+// the hash of the one before it so a modified, removed or inserted entry
+// breaks the chain
+type Chain struct {
+	sync.Mutex
+	w    io.Writer
+	seq  uint64
+	head string
+	// err - the error of the last append, nil once an append succeeds
+	err    error
+	closed bool
+}
+
+// NewChain returns a chain writing to w which continues after the entry
+// seq whose hash is head, an empty log starts at 0 and Genesis
+func NewChain(w io.Writer, seq uint64, head string) *Chain {
+	return &Chain{w: w, seq: seq, head: head}
+}
+
+// Open verifies the log at path and returns a chain appending to it, the
+// file is created when it does not exist
+func Open(path string) (*Chain, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	last, _, err := Verify(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewChain(f, last.Seq, last.Hash), nil
+}
+
+// Append adds the event as the next entry
+func (c *Chain) Append(event interface{}) (Entry, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return Entry{}, ErrClosed
+	}
+	entry := Entry{
+		Seq:      c.seq + 1,
+		PrevHash: c.head,
+		Event:    b,
+	}
+	entry.Hash = Hash(entry.Seq, entry.PrevHash, entry.Event)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	// the chain only moves on once the entry is written
+	if _, err := c.w.Write(append(line, '\n')); err != nil {
+		c.err = err
+		return Entry{}, err
+	}
+	c.err = nil
+	c.seq = entry.Seq
+	c.head = entry.Hash
+	return entry, nil
+}
+
+// Head returns the sequence number and the hash of the last entry
+func (c *Chain) Head() (uint64, string) {
+	c.Lock()
+	defer c.Unlock()
+	return c.seq, c.head
+}
+
+// Err returns the error of the last append, the chain can not be written
+// while it is set
+func (c *Chain) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+// Close syncs and closes the writer of the chain when it is a file, the
+// later appends fail
+func (c *Chain) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	if syncer, ok := c.w.(interface{ Sync() error }); ok {
+		err = syncer.Sync()
+	}
+	if closer, ok := c.w.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+// Verify reads the entries of r and checks that they are numbered without
+// gaps from 1 and that each one links to the hash of the one before it, it
+// returns the last entry and the number of entries. The error names the
+// line of the first broken entry.
+func Verify(r io.Reader) (Entry, int, error) {
+	last := Entry{Hash: Genesis}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			return last, line - 1, fmt.Errorf("%w: line %d: empty line", ErrBrokenChain, line)
+		}
+		var entry Entry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return last, line - 1, fmt.Errorf("%w: line %d: %s", ErrBrokenChain, line, err)
+		}
+		switch {
+		case entry.Seq != last.Seq+1:
+			return last, line - 1, fmt.Errorf("%w: line %d: expected entry %d, got %d", ErrBrokenChain, line, last.Seq+1, entry.Seq)
+		case entry.PrevHash != last.Hash:
+			return last, line - 1, fmt.Errorf("%w: line %d: entry %d does not link to entry %d", ErrBrokenChain, line, entry.Seq, last.Seq)
+		case entry.Hash != Hash(entry.Seq, entry.PrevHash, entry.Event):
+			return last, line - 1, fmt.Errorf("%w: line %d: entry %d was modified", ErrBrokenChain, line, entry.Seq)
+		}
+		last = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return last, line, err
+	}
+	return last, line, nil
+}
+
+// VerifyHead is Verify which also checks that the log ends at the entry seq
+// with the hash head, a truncated log only shows this way
+func VerifyHead(r io.Reader, seq uint64, head string) (Entry, int, error) {
+	last, n, err := Verify(r)
+	if err != nil {
+		return last, n, err
+	}
+	if last.Seq != seq || last.Hash != head {
+		return last, n, fmt.Errorf("%w: expected entry %d with hash %s, got entry %d with hash %s", ErrHead, seq, head, last.Seq, last.Hash)
+	}
+	return last, n, nil
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type event struct {
+	Type    string `json:"type"`
+	Account string `json:"account"`
+}
+
+func writeChain(t *testing.T, n int) (*bytes.Buffer, *Chain) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	chain := NewChain(buf, 0, Genesis)
+	for i := 0; i < n; i++ {
+		if _, err := chain.Append(event{Type: "login.succeeded", Account: "test"}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	return buf, chain
+}
+
+func TestVerify(t *testing.T) {
+	buf, chain := writeChain(t, 3)
+	seq, head := chain.Head()
+
+	last, n, err := Verify(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if n != 3 || last.Seq != seq || last.Hash != head {
+		t.Fatalf("Expected 3 entries ending at %d %s, got: %d entries ending at %d %s", seq, head, n, last.Seq, last.Hash)
+	}
+
+	_, n, err = Verify(strings.NewReader(""))
+	if err != nil || n != 0 {
+		t.Fatalf("Expected an empty log to be valid, got: %d entries, %v", n, err)
+	}
+}
+
+func TestVerifyBroken(t *testing.T) {
+	buf, _ := writeChain(t, 3)
+	lines := strings.SplitAfter(buf.String(), "\n")
+
+	tests := []struct {
+		name string
+		log  string
+		line string
+	}{
+		{"modified", lines[0] + strings.Replace(lines[1], `"account":"test"`, `"account":"evil"`, 1) + lines[2], "line 2"},
+		{"removed", lines[0] + lines[2], "line 2"},
+		{"swapped", lines[1] + lines[0] + lines[2], "line 1"},
+		{"inserted", lines[0] + lines[0] + lines[1] + lines[2], "line 2"},
+		{"malformed", lines[0] + "{\n" + lines[1], "line 2"},
+		{"rehashed", lines[0] + rehash(t, lines[1]) + lines[2], "line 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Verify(strings.NewReader(tt.log))
+			if !errors.Is(err, ErrBrokenChain) {
+				t.Fatalf("Expected error: %v, got: %v", ErrBrokenChain, err)
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Fatalf("Expected the error to name %s, got: %v", tt.line, err)
+			}
+		})
+	}
+}
+
+// rehash changes the account of an entry and recomputes its hash, only the
+// link of the next entry shows the change
+func rehash(t *testing.T, line string) string {
+	t.Helper()
+	var entry Entry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	entry.Event = bytes.Replace(entry.Event, []byte(`"test"`), []byte(`"evil"`), 1)
+	entry.Hash = Hash(entry.Seq, entry.PrevHash, entry.Event)
+	b, _ := json.Marshal(entry)
+	return string(b) + "\n"
+}
+
+func TestVerifyHead(t *testing.T) {
+	buf, chain := writeChain(t, 3)
+	seq, head := chain.Head()
+	if _, _, err := VerifyHead(bytes.NewReader(buf.Bytes()), seq, head); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// a truncated log and a rehashed last entry are valid chains which miss
+	// the head
+	lines := strings.SplitAfter(buf.String(), "\n")
+	for _, log := range []string{lines[0] + lines[1], lines[0] + lines[1] + rehash(t, lines[2])} {
+		_, _, err := VerifyHead(strings.NewReader(log), seq, head)
+		if !errors.Is(err, ErrHead) {
+			t.Fatalf("Expected error: %v, got: %v", ErrHead, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	chain, err := Open(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	chain.Append(event{Type: "account.created", Account: "test"})
+	chain.Append(event{Type: "login.succeeded", Account: "test"})
+	chain.Close()
+
+	// the chain resumes after the last entry of the file
+	chain, err = Open(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	entry, err := chain.Append(event{Type: "logout", Account: "test"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	chain.Close()
+	if entry.Seq != 3 {
+		t.Fatalf("Expected entry: %d, got: %d", 3, entry.Seq)
+	}
+
+	f, _ := os.Open(path)
+	_, n, err := Verify(f)
+	f.Close()
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 valid entries, got: %d, %v", n, err)
+	}
+
+	// a broken file is refused
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, bytes.Replace(data, []byte("logout"), []byte("login!"), 1), 0o600)
+	if _, err := Open(path); !errors.Is(err, ErrBrokenChain) {
+		t.Fatalf("Expected error: %v, got: %v", ErrBrokenChain, err)
+	}
+}
@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked when
+// server.tls.reload_interval is unset
+var DefaultReloadInterval = 10 * time.Second
+
+var ErrNoCertificates = errors.New("no certificates found")
+
+// Reloader serves the certificate of a key pair and loads it again when
+// the files change, the running certificate is kept while the files are
+// invalid, e.g. between the writes of the certificate and the key
+type Reloader struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	// stamps - the size and the modification time of the loaded files
+	stamps [2]stamp
+}
+
+type stamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewReloader loads the key pair of the files
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the key pair of the files again
+func (r *Reloader) Reload() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.cert = &cert
+	r.stamps = stamps
+	return nil
+}
+
+// GetCertificate returns the running certificate, it is the
+// tls.Config.GetCertificate of the servers
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files every interval until ctx is done and reloads the
+// key pair once they changed
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Error("reload certificate error", "cert_file", r.certFile, "error", err)
+			continue
+		}
+		slog.Info("certificate reloaded", "cert_file", r.certFile)
+	}
+}
+
+// changed reports whether the files differ from the loaded ones
+func (r *Reloader) changed() bool {
+	stamps, err := r.stat()
+	if err != nil {
+		// a file being replaced is missing for a moment
+		return false
+	}
+	r.RLock()
+	defer r.RUnlock()
+	return stamps != r.stamps
+}
+
+func (r *Reloader) stat() ([2]stamp, error) {
+	var stamps [2]stamp
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = stamp{size: info.Size(), modTime: info.ModTime()}
+	}
+	return stamps, nil
+}
+
+// ServerConfig returns the TLS config of the servers serving the
+// certificate of r, the client certificates signed by a CA of
+// cfg.ClientCAFile are verified when the client sends one
+func ServerConfig(cfg config.TLSConfig, r *Reloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pool, err := LoadPool(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = pool
+	// the routes which need a certificate check it themselves
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// LoadPool returns the pool of the PEM certificates of the file
+func LoadPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: %w", file, ErrNoCertificates)
+	}
+	return pool, nil
+}
@@ -0,0 +1,243 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+)
+
+// authority signs the certificates of the tests
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of serial
+func (ca *authority) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the files of a test are written within the resolution of the clock
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serial(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloader(t *testing.T) {
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert, now)
+	writeFile(t, keyFile, key, now)
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if s := serial(t, r); s != 2 {
+		t.Fatalf("Expected serial: 2, got: %d", s)
+	}
+	if r.changed() {
+		t.Fatalf("Expected the files to be unchanged")
+	}
+
+	// a certificate without its key keeps the running one
+	cert, key = ca.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert, now.Add(time.Second))
+	if !r.changed() {
+		t.Fatalf("Expected the files to be changed")
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatalf("Expected an error of the mismatched key pair")
+	}
+	if s := serial(t, r); s != 2 {
+		t.Fatalf("Expected serial: 2, got: %d", s)
+	}
+
+	writeFile(t, keyFile, key, now.Add(time.Second))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if s := serial(t, r); s != 3 {
+		t.Fatalf("Expected serial: 3, got: %d", s)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert, now)
+	writeFile(t, keyFile, key, now)
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	cert, key = ca.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	writeFile(t, keyFile, key, now.Add(time.Second))
+	writeFile(t, certFile, cert, now.Add(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for serial(t, r) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the certificate to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, cfg.CertFile, cert, time.Now())
+	writeFile(t, cfg.KeyFile, key, time.Now())
+	writeFile(t, cfg.ClientCAFile, ca.pem, time.Now())
+
+	r, err := NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := ServerConfig(cfg, r)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// StartTLS would replace the certificate of the config
+	server.Listener = tls.NewListener(server.Listener, tlsConfig)
+	server.Start()
+	defer server.Close()
+	url := "https://" + server.Listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	request := func(certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the client certificate is optional
+	if code := request(); code != http.StatusForbidden {
+		t.Fatalf("Expected status code: %d, got: %d", http.StatusForbidden, code)
+	}
+	cert, key = ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	client, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := request(client); code != http.StatusNoContent {
+		t.Fatalf("Expected status code: %d, got: %d", http.StatusNoContent, code)
+	}
+
+	if _, err := LoadPool(cfg.KeyFile); err == nil {
+		t.Fatalf("Expected an error of a file without certificates")
+	}
+}
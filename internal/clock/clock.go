@@ -0,0 +1,129 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the services, tests replace it with a Fake
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the clock of the system
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Or returns c, or Real when c is nil
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Sleep waits d on c, it reports false when stop closes first. A Fake
+// wakes the sleepers once it is moved past their time, other clocks wait
+// for the system timer
+func Sleep(c Clock, d time.Duration, stop <-chan struct{}) bool {
+	var wake <-chan time.Time
+	if f, ok := Or(c).(*Fake); ok {
+		wake = f.after(d)
+	} else {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		wake = timer.C
+	}
+	select {
+	case <-wake:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Fake is a clock which only moves when it is told to
+type Fake struct {
+	sync.Mutex
+	now      time.Time
+	sleepers []sleeper
+	// changed is signaled when a sleeper is added
+	changed *sync.Cond
+}
+
+// sleeper - a Sleep on a Fake, wake receives the time once it is reached
+type sleeper struct {
+	until time.Time
+	wake  chan time.Time
+}
+
+// NewFake returns a fake clock stopped at now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.Mutex)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d and returns the new time
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.Lock()
+	defer f.Unlock()
+	f.now = f.now.Add(d)
+	f.wake()
+	return f.now
+}
+
+// Set moves the clock to now
+func (f *Fake) Set(now time.Time) {
+	f.Lock()
+	defer f.Unlock()
+	f.now = now
+	f.wake()
+}
+
+// BlockUntil waits until n sleepers wait on the clock, tests call it
+// before Advance so the sleep they move past has started
+func (f *Fake) BlockUntil(n int) {
+	f.Lock()
+	defer f.Unlock()
+	for len(f.sleepers) < n {
+		f.changed.Wait()
+	}
+}
+
+// after returns a channel which receives the time once the clock is d
+// ahead of now
+func (f *Fake) after(d time.Duration) <-chan time.Time {
+	f.Lock()
+	defer f.Unlock()
+	ch := make(chan time.Time, 1)
+	f.sleepers = append(f.sleepers, sleeper{until: f.now.Add(d), wake: ch})
+	f.wake()
+	f.changed.Broadcast()
+	return ch
+}
+
+// wake releases the sleepers whose time is reached, the caller holds the
+// lock
+func (f *Fake) wake() {
+	sleepers := f.sleepers[:0]
+	for _, s := range f.sleepers {
+		if f.now.Before(s.until) {
+			sleepers = append(sleepers, s)
+			continue
+		}
+		s.wake <- f.now
+	}
+	f.sleepers = sleepers
+}
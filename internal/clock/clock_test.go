@@ -0,0 +1,62 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Expected time: %v, got: %v", start, c.Now())
+	}
+	if now := c.Advance(time.Hour); !now.Equal(start.Add(time.Hour)) || !c.Now().Equal(now) {
+		t.Fatalf("Expected time: %v, got: %v", start.Add(time.Hour), c.Now())
+	}
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Expected time: %v, got: %v", start, c.Now())
+	}
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != Real {
+		t.Fatalf("Expected the real clock")
+	}
+	c := NewFake(time.Time{})
+	if Or(c) != Clock(c) {
+		t.Fatalf("Expected the fake clock")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	woke := make(chan bool)
+	go func() {
+		woke <- Sleep(c, time.Minute, nil)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute - time.Second)
+	select {
+	case <-woke:
+		t.Fatalf("Expected the sleep to last until %v", start.Add(time.Minute))
+	default:
+	}
+	c.Advance(time.Second)
+	if !<-woke {
+		t.Fatalf("Expected the sleep to end")
+	}
+
+	// a closed stop ends the sleep
+	stop := make(chan struct{})
+	close(stop)
+	if Sleep(c, time.Minute, stop) {
+		t.Fatalf("Expected the sleep to be stopped")
+	}
+	if Sleep(nil, time.Hour, stop) {
+		t.Fatalf("Expected the sleep to be stopped")
+	}
+}
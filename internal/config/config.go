@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 const (
 	Dev = "dev"
 	Pre = "pre"
@@ -7,10 +9,272 @@ const (
 )
 
 type AppConfig struct {
-	HttpPort uint64 `yaml:"http_port"`
-	Env      string `yaml:"env"`
+	HttpPort  uint64          `yaml:"http_port"`
+	GrpcPort  uint64          `yaml:"grpc_port"`
+	Env       string          `yaml:"env"`
+	Server    ServerConfig    `yaml:"server"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Admin     AdminConfig     `yaml:"admin"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Lockout   LockoutConfig   `yaml:"lockout"`
+	TOTP      TOTPConfig      `yaml:"totp"`
+	Password  PasswordConfig  `yaml:"password"`
+	Notifier  NotifierConfig  `yaml:"notifier"`
+	Policy    PolicyConfig    `yaml:"policy"`
+	Errors    ErrorsConfig    `yaml:"errors"`
+	Events    EventsConfig    `yaml:"events"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+	Audit     AuditConfig     `yaml:"audit"`
+}
+
+// ServerConfig - the http and gRPC servers
+type ServerConfig struct {
+	// ReadHeaderTimeout and ReadTimeout bound the reading of the headers
+	// and of the whole request.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds the writing of a response, it also ends the
+	// event streams so it is off when zero.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// IdleTimeout closes the keep-alive connections without a request.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds the wait for the requests in flight and the
+	// flush of the bank once a SIGTERM or SIGINT arrived.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the IPs and CIDRs of the proxies whose
+	// X-Forwarded-For and X-Real-IP headers name the client, the headers
+	// are ignored when it is empty and the client is the peer address. It
+	// is a comma separated list in the environment.
+	TrustedProxies []string  `yaml:"trusted_proxies"`
+	TLS            TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig - the certificate of the http and gRPC servers, they serve
+// plain text when it is unset
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReloadInterval is how often the files are checked for a new
+	// certificate.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// ClientCAFile holds the CAs of the client certificates, the admin
+	// routes need a certificate signed by one of them when it is set.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// JWTConfig - the access tokens
+type JWTConfig struct {
+	// Secret is the HMAC key of the tokens, at least 32 bytes. A random
+	// key is generated in dev when it is empty, so the tokens do not
+	// survive a restart.
+	Secret string `yaml:"secret"`
+	// TokenTTL is how long an issued token is valid.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// StorageConfig - where the accounts and the transactions are kept
+type StorageConfig struct {
+	// Driver is "memory", the only driver so far, the data is lost on a
+	// restart. The audit log and the outbox files are configured with their
+	// sections.
+	Driver string `yaml:"driver"`
+}
+
+// LogConfig - the structured logs
+type LogConfig struct {
+	// Level is "debug", "info", "warn" or "error", info when unset.
+	Level string `yaml:"level"`
+	// Format is "json" or "text".
+	Format string `yaml:"format"`
+}
+
+// TracingConfig - OpenTelemetry tracing, it is disabled unless an exporter
+// is set
+type TracingConfig struct {
+	// Exporter is "stdout" or "otlp".
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of the OTLP gRPC collector, the exporter
+	// default when empty.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure sends the spans to the collector without TLS.
+	Insecure bool `yaml:"insecure"`
+	// ServiceName is the service.name of the spans.
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the share of the new traces which are sampled, every
+	// trace is sampled when it is zero. A trace continued from a caller
+	// follows the decision of the caller.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// AdminConfig - settings of the admin route group
+type AdminConfig struct {
+	// Token is the shared secret expected in the X-Admin-Token header.
+	// The admin routes reject every request when it is empty.
+	Token string `yaml:"token"`
+}
+
+// RateLimitConfig - token buckets of the route groups per IP and per
+// account
+type RateLimitConfig struct {
+	// Store is "memory", the only store so far, every instance of the
+	// service then keeps its own buckets.
+	Store string `yaml:"store"`
+	// Auth limits the login, the registration and the password reset per IP.
+	Auth RateLimitRule `yaml:"auth"`
+	// Read limits the authenticated GET routes per IP and per account.
+	Read RateLimitRule `yaml:"read"`
+	// Write limits the other authenticated routes per IP and per account.
+	Write RateLimitRule `yaml:"write"`
+}
+
+// RateLimitRule - a token bucket, it is disabled when Requests is zero
+type RateLimitRule struct {
+	// Requests is the number of requests refilled every Period, a second
+	// when unset.
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	// Burst is the size of the bucket, Requests when unset.
+	Burst int `yaml:"burst"`
+}
+
+// LockoutConfig - brute-force protection of the login endpoint
+type LockoutConfig struct {
+	// MaxAttempts is the number of consecutive failures of an account
+	// before it is locked.
+	MaxAttempts int `yaml:"max_attempts"`
+	// IPMaxAttempts is the number of consecutive failures from an IP
+	// before it is locked.
+	IPMaxAttempts int `yaml:"ip_max_attempts"`
+	// BaseDelay is the backoff after the first failure, it doubles on
+	// every following failure up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	// LockDuration is how long a lock lasts before it is released
+	// automatically.
+	LockDuration time.Duration `yaml:"lock_duration"`
+}
+
+// TOTPConfig - second factor settings
+type TOTPConfig struct {
+	// Issuer is the name shown by authenticator apps.
+	Issuer string `yaml:"issuer"`
+	// Skew is the number of 30 seconds steps accepted before and after now,
+	// one when unset.
+	Skew int `yaml:"skew"`
+	// StepUpAmount is the amount above which a withdraw or a transfer of an
+	// enrolled account needs a code, zero disables the step-up.
+	StepUpAmount int `yaml:"step_up_amount"`
+}
+
+// PasswordConfig - password reset settings
+type PasswordConfig struct {
+	// ResetTokenTTL is how long a reset token can be used.
+	ResetTokenTTL time.Duration `yaml:"reset_token_ttl"`
+}
+
+// NotifierConfig - how messages are delivered to the users
+type NotifierConfig struct {
+	// Type is "log" or "file".
+	Type string `yaml:"type"`
+	// Path is the file the "file" notifier appends to.
+	Path string `yaml:"path"`
+}
+
+// PolicyConfig - input rules of the registration and the password changes,
+// the zero values fall back to the defaults of the policy package
+type PolicyConfig struct {
+	PasswordMinLength int  `yaml:"password_min_length"`
+	PasswordMaxLength int  `yaml:"password_max_length"`
+	RequireUpper      bool `yaml:"require_upper"`
+	RequireLower      bool `yaml:"require_lower"`
+	RequireDigit      bool `yaml:"require_digit"`
+	RequireSymbol     bool `yaml:"require_symbol"`
+	// BreachedPasswordsFile is a file with one known leaked password per
+	// line, empty lines and lines starting with # are skipped.
+	BreachedPasswordsFile string `yaml:"breached_passwords_file"`
+	NameMinLength         int    `yaml:"name_min_length"`
+	NameMaxLength         int    `yaml:"name_max_length"`
+	// NamePattern is the regular expression a name must match.
+	NamePattern string `yaml:"name_pattern"`
+}
+
+// ErrorsConfig - how the errors are rendered
+type ErrorsConfig struct {
+	// Format is "envelope" or "problem", clients can always ask for the
+	// problem format with the Accept: application/problem+json header.
+	Format string `yaml:"format"`
+	// ProblemTypeBase prefixes the kebab case error code to build the type
+	// URI of a problem document.
+	ProblemTypeBase string `yaml:"problem_type_base"`
 }
 
 func (cfg *AppConfig) IsDevEnv() bool {
-	return cfg.Env == "dev"
+	return cfg.Env == Dev
+}
+
+// EventsConfig - the balance and transaction event stream
+type EventsConfig struct {
+	// History is the number of latest events kept per account for the
+	// clients which resume with Last-Event-ID.
+	History int `yaml:"history"`
+	// Buffer is the number of undelivered events a client may fall
+	// behind before its stream is closed.
+	Buffer int `yaml:"buffer"`
+	// Heartbeat is the interval of the keep-alive comments of a stream.
+	Heartbeat time.Duration `yaml:"heartbeat"`
+}
+
+// WebhooksConfig - delivery of the events to the webhooks of the accounts
+type WebhooksConfig struct {
+	// MaxAttempts is the number of deliveries of an event before it is
+	// moved to the dead-letter list.
+	MaxAttempts int `yaml:"max_attempts"`
+	// BaseDelay is the wait after the first failed delivery, it doubles
+	// on every following failure up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	// Timeout bounds a single delivery.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxPerAccount is the number of webhooks an account may register.
+	MaxPerAccount int `yaml:"max_per_account"`
+	// AllowPrivateNetworks lets the webhooks target loopback, private and
+	// link-local addresses, it is meant for local testing only.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+// OutboxConfig - publication of the events of the committed transactions
+type OutboxConfig struct {
+	// Publisher is "log", "file" or "nats".
+	Publisher string `yaml:"publisher"`
+	// Path is the file the "file" publisher appends to.
+	Path string `yaml:"path"`
+	// Store is the file which keeps the unpublished messages across a
+	// restart, they are only kept in memory when it is empty.
+	Store string `yaml:"store"`
+	// URL is the address of the server of the "nats" publisher.
+	URL string `yaml:"url"`
+	// Subject prefixes the subject of a message, the account is appended.
+	Subject string `yaml:"subject"`
+	// Interval is how often the relay looks for messages due for a retry.
+	Interval time.Duration `yaml:"interval"`
+	// BatchSize is the number of messages the relay reads at once.
+	BatchSize int `yaml:"batch_size"`
+	// BaseDelay is the wait after the first failed publication of a
+	// message, it doubles on every following failure up to MaxDelay.
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	// Timeout bounds a single publication.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AuditConfig - the hash-chained audit log
+type AuditConfig struct {
+	// Path is the file the audit entries are appended to, the chain is
+	// verified when the service starts. The entries are only logged when
+	// it is empty.
+	Path string `yaml:"path"`
 }
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "application.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	dev, prd := Defaults(Dev), Defaults(Prd)
+	if dev.Log.Level != "debug" || prd.Log.Level != "info" {
+		t.Fatalf("Expected log levels: debug and info, got: %s and %s", dev.Log.Level, prd.Log.Level)
+	}
+	if dev.RateLimit.Auth.Requests != 0 {
+		t.Fatalf("Expected no rate limit in dev, got: %+v", dev.RateLimit)
+	}
+	if prd.RateLimit.Auth.Requests == 0 || prd.Tracing.SampleRatio != 0.1 {
+		t.Fatalf("Expected the rate limits and the sampling of prd, got: %+v", prd)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, "env: dev\nhttp_port: 9090\nlog:\n  format: json\n")
+	t.Setenv("BANK_HTTP_PORT", "9191")
+	t.Setenv("BANK_JWT_TOKEN_TTL", "10m")
+	t.Setenv("BANK_RATE_LIMIT_AUTH_REQUESTS", "3")
+	t.Setenv("BANK_SERVER_TRUSTED_PROXIES", "10.0.0.1, 192.168.0.0/16")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if cfg.HttpPort != 9191 {
+		t.Fatalf("Expected http port: 9191, got: %d", cfg.HttpPort)
+	}
+	if cfg.JWT.TokenTTL != 10*time.Minute {
+		t.Fatalf("Expected token ttl: %v, got: %v", 10*time.Minute, cfg.JWT.TokenTTL)
+	}
+	if cfg.RateLimit.Auth.Requests != 3 {
+		t.Fatalf("Expected auth requests: 3, got: %d", cfg.RateLimit.Auth.Requests)
+	}
+	if proxies := cfg.Server.TrustedProxies; !reflect.DeepEqual(proxies, []string{"10.0.0.1", "192.168.0.0/16"}) {
+		t.Fatalf("Expected trusted proxies: [10.0.0.1 192.168.0.0/16], got: %v", proxies)
+	}
+	// the file overrides the defaults of dev, the rest is kept
+	if cfg.Log.Format != "json" || cfg.Log.Level != "debug" {
+		t.Fatalf("Expected log: json debug, got: %+v", cfg.Log)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeConfig(t, "env: dev\n")
+	t.Setenv("BANK_ENV", Prd)
+	t.Setenv("BANK_JWT_SECRET", strings.Repeat("s", MinJWTSecretLen))
+	t.Setenv("BANK_AUDIT_PATH", "audit.log")
+	t.Setenv("BANK_OUTBOX_STORE", "outbox.jsonl")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if cfg.Env != Prd || cfg.Tracing.SampleRatio != 0.1 {
+		t.Fatalf("Expected the defaults of prd, got: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		content string
+		env     map[string]string
+		expect  string
+	}{
+		"unknown key":     {content: "env: dev\nhttp_prot: 1\n", expect: "http_prot"},
+		"invalid env var": {content: "env: dev\n", env: map[string]string{"BANK_HTTP_PORT": "port"}, expect: "BANK_HTTP_PORT"},
+		"invalid config":  {content: "env: prd\n", expect: "jwt.secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeConfig(t, test.content))
+			if err == nil || !strings.Contains(err.Error(), test.expect) {
+				t.Fatalf("Expected an error naming: %s, got: %v", test.expect, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Defaults(Prd)
+	cfg.GrpcPort = cfg.HttpPort
+	cfg.JWT.Secret = "short"
+	cfg.Server.TLS.CertFile = "cert.pem"
+	cfg.Log.Level = "verbose"
+	cfg.Outbox.Publisher = "nats"
+	cfg.Server.ReadTimeout = -time.Second
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "proxy"}
+	cfg.Webhooks.AllowPrivateNetworks = true
+
+	err := cfg.Validate()
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", ErrInvalid, err)
+	}
+	for _, path := range []string{
+		"grpc_port", "jwt.secret", "server.tls", "server.tls.cert_file", "audit.path",
+		"log.level", "outbox.url", "outbox.store", "server.read_timeout", "server.trusted_proxies",
+		"webhooks.allow_private_networks",
+	} {
+		if !strings.Contains(err.Error(), path+":") {
+			t.Fatalf("Expected an error of: %s, got: %v", path, err)
+		}
+	}
+
+	dev := Defaults(Dev)
+	if err := dev.Validate(); err != nil {
+		t.Fatalf("Expected the defaults of dev to be valid, got: %v", err)
+	}
+	dev.Server.TLS.ClientCAFile = "config_test.go"
+	if err := dev.Validate(); err == nil || !strings.Contains(err.Error(), "server.tls.client_ca_file:") {
+		t.Fatalf("Expected an error of: server.tls.client_ca_file, got: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cfg := Defaults(Dev)
+	next := Defaults(Dev)
+	next.Admin.Token = "token"
+	next.Server.IdleTimeout = time.Hour
+
+	keys := cfg.Diff(&next)
+	if !reflect.DeepEqual(keys, []string{"server", "admin"}) {
+		t.Fatalf("Expected keys: [server admin], got: %v", keys)
+	}
+	if Reloadable["server"] || !Reloadable["admin"] {
+		t.Fatalf("Expected only admin to be reloadable, got: %v", Reloadable)
+	}
+}
+
+func TestLoadDeployment(t *testing.T) {
+	if _, err := Load("../../deployment/application.yaml"); err != nil {
+		t.Fatalf("Expected the deployed config to be valid, got: %v", err)
+	}
+}
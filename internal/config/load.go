@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables which override the file, the
+// rest of the name is the yaml path in upper case joined by underscores,
+// e.g. BANK_JWT_SECRET or BANK_RATE_LIMIT_AUTH_REQUESTS
+const EnvPrefix = "BANK_"
+
+// Defaults returns the settings of an environment before the file and the
+// environment variables are applied, the settings left zero fall back to
+// the defaults of their packages
+func Defaults(env string) AppConfig {
+	cfg := AppConfig{
+		HttpPort: 8080,
+		Env:      env,
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			IdleTimeout:       time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		JWT:     JWTConfig{TokenTTL: 5 * time.Minute},
+		Storage: StorageConfig{Driver: "memory"},
+		Log:     LogConfig{Level: "info", Format: "json"},
+	}
+	switch env {
+	case Dev:
+		cfg.Log = LogConfig{Level: "debug", Format: "text"}
+	case Pre, Prd:
+		cfg.RateLimit = RateLimitConfig{
+			Store: "memory",
+			Auth:  RateLimitRule{Requests: 10, Period: time.Minute, Burst: 5},
+			Read:  RateLimitRule{Requests: 20, Period: time.Second, Burst: 40},
+			Write: RateLimitRule{Requests: 5, Period: time.Second, Burst: 10},
+		}
+		if env == Prd {
+			cfg.Tracing.SampleRatio = 0.1
+		}
+	}
+	return cfg
+}
+
+// Load reads the config of the yaml file at path over the defaults of its
+// environment, applies the environment variables and validates the result.
+// The file is optional when path is empty.
+func Load(path string) (*AppConfig, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the environment picks the defaults the file is applied to
+	var probe struct {
+		Env string `yaml:"env"`
+	}
+	if err := yaml.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	env := probe.Env
+	if v, ok := os.LookupEnv(EnvPrefix + "ENV"); ok {
+		env = v
+	}
+	if env == "" {
+		env = Dev
+	}
+
+	cfg := Defaults(env)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// a misspelt key fails instead of being ignored
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := ApplyEnv(&cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ApplyEnv overrides the settings with the environment variables found by
+// lookup, durations use the syntax of time.ParseDuration
+func ApplyEnv(cfg *AppConfig, lookup func(string) (string, bool)) error {
+	var errs []error
+	walk(reflect.ValueOf(cfg).Elem(), "", func(path string, v reflect.Value) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+		s, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if err := setValue(v, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walk calls fn with the yaml path of every setting of v
+func walk(v reflect.Value, prefix string, fn func(path string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		path := tag
+		if prefix != "" {
+			path = prefix + "." + tag
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			walk(field, path, fn)
+			continue
+		}
+		fn(path, field)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Reloadable are the sections which are applied to the running service on
+// a SIGHUP, the other changes need a restart
+var Reloadable = map[string]bool{
+	"log":        true,
+	"admin":      true,
+	"rate_limit": true,
+}
+
+// Diff returns the yaml keys of the top level settings which differ in next
+func (cfg *AppConfig) Diff(next *AppConfig) []string {
+	var keys []string
+	a, b := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+		keys = append(keys, strings.Split(a.Type().Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	return keys
+}
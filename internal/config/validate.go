@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// MinJWTSecretLen is the shortest accepted HMAC key of the tokens
+const MinJWTSecretLen = 32
+
+var ErrInvalid = errors.New("invalid config")
+
+// Validate checks the settings together, the error lists every invalid
+// one by its yaml path
+func (cfg *AppConfig) Validate() error {
+	var errs []error
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(path, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		fail(path, "%q is not one of %q", value, allowed)
+	}
+
+	walk(reflect.ValueOf(cfg).Elem(), "", func(path string, v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int64:
+			if v.Int() < 0 {
+				fail(path, "must not be negative")
+			}
+		case reflect.Float64:
+			if v.Float() < 0 {
+				fail(path, "must not be negative")
+			}
+		}
+	})
+
+	oneOf("env", cfg.Env, Dev, Pre, Prd)
+	if cfg.HttpPort == 0 || cfg.HttpPort > 65535 {
+		fail("http_port", "must be between 1 and 65535")
+	}
+	if cfg.GrpcPort > 65535 {
+		fail("grpc_port", "must be at most 65535")
+	}
+	if cfg.GrpcPort != 0 && cfg.GrpcPort == cfg.HttpPort {
+		fail("grpc_port", "must differ from http_port")
+	}
+
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			fail("server.trusted_proxies", "%q is neither an IP nor a CIDR", proxy)
+		}
+	}
+
+	tls := cfg.Server.TLS
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		fail("server.tls", "cert_file and key_file must be set together")
+	}
+	if tls.ClientCAFile != "" && tls.CertFile == "" {
+		fail("server.tls.client_ca_file", "needs cert_file and key_file")
+	}
+	for _, f := range []struct{ path, file string }{
+		{"server.tls.cert_file", tls.CertFile},
+		{"server.tls.key_file", tls.KeyFile},
+		{"server.tls.client_ca_file", tls.ClientCAFile},
+	} {
+		if f.file == "" {
+			continue
+		}
+		if _, err := os.Stat(f.file); err != nil {
+			fail(f.path, "%s", err)
+		}
+	}
+
+	switch {
+	case cfg.JWT.Secret == "" && !cfg.IsDevEnv():
+		fail("jwt.secret", "is required outside dev")
+	case cfg.JWT.Secret != "" && len(cfg.JWT.Secret) < MinJWTSecretLen:
+		fail("jwt.secret", "must be at least %d bytes", MinJWTSecretLen)
+	}
+	oneOf("storage.driver", cfg.Storage.Driver, "", "memory")
+	if cfg.Env == Prd && cfg.Audit.Path == "" {
+		fail("audit.path", "is required in prd")
+	}
+
+	oneOf("log.level", strings.ToLower(cfg.Log.Level), "", "debug", "info", "warn", "error")
+	oneOf("log.format", cfg.Log.Format, "", "json", "text")
+	oneOf("tracing.exporter", cfg.Tracing.Exporter, "", "stdout", "otlp")
+	if cfg.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be at most 1")
+	}
+	oneOf("rate_limit.store", cfg.RateLimit.Store, "", "memory")
+	oneOf("errors.format", cfg.Errors.Format, "", "envelope", "problem")
+
+	oneOf("notifier.type", cfg.Notifier.Type, "", "log", "file")
+	if cfg.Notifier.Type == "file" && cfg.Notifier.Path == "" {
+		fail("notifier.path", "is required by the file notifier")
+	}
+	oneOf("outbox.publisher", cfg.Outbox.Publisher, "", "log", "file", "nats")
+	if cfg.Outbox.Publisher == "file" && cfg.Outbox.Path == "" {
+		fail("outbox.path", "is required by the file publisher")
+	}
+	if cfg.Outbox.Publisher == "nats" && cfg.Outbox.URL == "" {
+		fail("outbox.url", "is required by the nats publisher")
+	}
+	if cfg.Env == Prd && cfg.Outbox.Store == "" {
+		fail("outbox.store", "is required in prd")
+	}
+
+	if cfg.Env == Prd && cfg.Webhooks.AllowPrivateNetworks {
+		fail("webhooks.allow_private_networks", "must be off in prd")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrInvalid, errors.Join(errs...))
+	}
+	return nil
+}
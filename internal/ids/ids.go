@@ -0,0 +1,45 @@
+package ids
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Generator returns the IDs of the new accounts, tokens and webhooks
+type Generator interface {
+	NewID() string
+}
+
+// UUID generates random UUIDs
+var UUID Generator = uuidGenerator{}
+
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// Or returns g, or UUID when g is nil
+func Or(g Generator) Generator {
+	if g == nil {
+		return UUID
+	}
+	return g
+}
+
+// Sequence generates UUIDs counting up from 1, the IDs of a test are the
+// same on every run
+type Sequence struct {
+	n atomic.Uint64
+}
+
+// NewSequence returns a sequence starting at 1
+func NewSequence() *Sequence {
+	return &Sequence{}
+}
+
+func (s *Sequence) NewID() string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.n.Add(1))
+}
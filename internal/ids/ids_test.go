@@ -0,0 +1,29 @@
+package ids
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSequence(t *testing.T) {
+	s := NewSequence()
+	for _, expected := range []string{
+		"00000000-0000-4000-8000-000000000001",
+		"00000000-0000-4000-8000-000000000002",
+	} {
+		id := s.NewID()
+		if id != expected {
+			t.Fatalf("Expected id: %s, got: %s", expected, id)
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			t.Fatalf("Expected a valid uuid, got: %v", err)
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	if a, b := Or(nil).NewID(), UUID.NewID(); a == b {
+		t.Fatalf("Expected random ids, got: %s twice", a)
+	}
+}
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RequestIDHeader carries the correlation ID of a request and its response
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the attribute of the request ID in the logs
+	RequestIDKey = "request_id"
+	// TraceIDKey and SpanIDKey are the attributes of the span of a record
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+	// Redacted replaces the secrets in the logs
+	Redacted = "[REDACTED]"
+
+	maxRequestIDLen = 128
+)
+
+var (
+	// sensitiveKeys are the attributes whose values never reach the logs
+	sensitiveKeys = map[string]bool{
+		"password":      true,
+		"old_password":  true,
+		"new_password":  true,
+		"pwd":           true,
+		"token":         true,
+		"access_token":  true,
+		"reset_token":   true,
+		"nonce":         true,
+		"secret":        true,
+		"totp":          true,
+		"code":          true,
+		"authorization": true,
+		"x-admin-token": true,
+	}
+	// jwtPattern finds the tokens within the messages and the values
+	jwtPattern       = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+)
+
+// New returns a logger writing to w, the request ID of the context is
+// added to every record and the secrets are redacted
+func New(w io.Writer, cfg config.LogConfig) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&contextHandler{handler})
+}
+
+// redact replaces the value of a sensitive attribute and the tokens within
+// a string
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() == slog.KindString && jwtPattern.MatchString(a.Value.String()) {
+		a.Value = slog.StringValue(jwtPattern.ReplaceAllString(a.Value.String(), Redacted))
+	}
+	return a
+}
+
+// contextHandler adds the request ID and the span of the context to the
+// records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	if ctx != nil {
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			r.AddAttrs(slog.String(TraceIDKey, span.TraceID().String()), slog.String(SpanIDKey, span.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, it is empty outside a request
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ValidRequestID reports whether an ID sent by a client can be kept, it
+// must be short and printable so it can not forge log lines
+func ValidRequestID(id string) bool {
+	return len(id) <= maxRequestIDLen && requestIDPattern.MatchString(id)
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "bank"
+
+	ActionDeposit  = "deposit"
+	ActionWithdraw = "withdraw"
+	ActionTransfer = "transfer"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var (
+	// Registry holds the metrics of the service, it is served by Handler
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "The handled HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "The duration of the HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	GRPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "The handled gRPC calls by method and code.",
+	}, []string{"method", "code"})
+	GRPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "The duration of the gRPC calls by method and code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	Transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "The deposits, withdraws and transfers by outcome and kind of error.",
+	}, []string{"action", "outcome", "error"})
+	TransactionAmount = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transaction_amount",
+		Help:      "The amount of the successful deposits, withdraws and transfers.",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 7),
+	}, []string{"action"})
+	Accounts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accounts",
+		Help:      "The number of open accounts.",
+	})
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "The requests rejected by the rate limiter by route group.",
+	}, []string{"group"})
+	LockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_wait_seconds",
+		Help:      "The wait for the account and transaction locks by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.000001, 10, 7),
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		GRPCRequests,
+		GRPCDuration,
+		Transactions,
+		TransactionAmount,
+		Accounts,
+		RateLimited,
+		LockWait,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveTransaction counts a deposit, withdraw or transfer, kind is empty
+// for a successful one whose amount is observed
+func ObserveTransaction(action string, amount int, kind string) {
+	if kind != "" {
+		Transactions.WithLabelValues(action, OutcomeFailure, kind).Inc()
+		return
+	}
+	Transactions.WithLabelValues(action, OutcomeSuccess, "").Inc()
+	TransactionAmount.WithLabelValues(action).Observe(float64(amount))
+}
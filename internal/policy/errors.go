@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordUpper    = errors.New("password needs an upper case letter")
+	ErrPasswordLower    = errors.New("password needs a lower case letter")
+	ErrPasswordDigit    = errors.New("password needs a digit")
+	ErrPasswordSymbol   = errors.New("password needs a symbol")
+	ErrPasswordBreached = errors.New("password appears in a data breach")
+	ErrNameTooShort     = errors.New("name is too short")
+	ErrNameTooLong      = errors.New("name is too long")
+	ErrNameCharacters   = errors.New("name contains characters which are not allowed")
+)
+
+// FieldError - a failing rule of a field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	err     error
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e FieldError) Unwrap() error {
+	return e.err
+}
+
+// ValidationError - every failing rule of a request
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// Add records that the field failed with err
+func (e *ValidationError) Add(field string, err error) {
+	e.Fields = append(e.Fields, FieldError{
+		Field:   field,
+		Message: err.Error(),
+		err:     err,
+	})
+}
+
+// Err returns nil when no field failed
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is match the error of any field
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		errs = append(errs, f)
+	}
+	return errs
+}
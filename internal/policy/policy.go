@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+)
+
+var (
+	DefaultPasswordMinLength = 8
+	DefaultPasswordMaxLength = 128
+	DefaultNameMinLength     = 1
+	DefaultNameMaxLength     = 64
+	DefaultNamePattern       = `^[\p{L}\p{N} .'_-]+$`
+)
+
+// Policy validates the passwords and the names of the users
+type Policy struct {
+	cfg         config.PolicyConfig
+	namePattern *regexp.Regexp
+	breached    map[string]struct{}
+}
+
+// New builds the policy of the config, it loads the breached password list
+func New(cfg config.PolicyConfig) (*Policy, error) {
+	if cfg.PasswordMinLength <= 0 {
+		cfg.PasswordMinLength = DefaultPasswordMinLength
+	}
+	if cfg.PasswordMaxLength <= 0 {
+		cfg.PasswordMaxLength = DefaultPasswordMaxLength
+	}
+	if cfg.NameMinLength <= 0 {
+		cfg.NameMinLength = DefaultNameMinLength
+	}
+	if cfg.NameMaxLength <= 0 {
+		cfg.NameMaxLength = DefaultNameMaxLength
+	}
+	if cfg.NamePattern == "" {
+		cfg.NamePattern = DefaultNamePattern
+	}
+
+	namePattern, err := regexp.Compile(cfg.NamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name pattern: %w", err)
+	}
+
+	p := &Policy{
+		cfg:         cfg,
+		namePattern: namePattern,
+		breached:    make(map[string]struct{}),
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		if err := p.loadBreached(cfg.BreachedPasswordsFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// ValidatePassword adds every rule the password fails to errs
+func (p *Policy) ValidatePassword(field, pwd string, errs *ValidationError) {
+	if p == nil {
+		return
+	}
+
+	length := utf8.RuneCountInString(pwd)
+	if length < p.cfg.PasswordMinLength {
+		errs.Add(field, ErrPasswordTooShort)
+	}
+	if length > p.cfg.PasswordMaxLength {
+		errs.Add(field, ErrPasswordTooLong)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range pwd {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		errs.Add(field, ErrPasswordUpper)
+	}
+	if p.cfg.RequireLower && !lower {
+		errs.Add(field, ErrPasswordLower)
+	}
+	if p.cfg.RequireDigit && !digit {
+		errs.Add(field, ErrPasswordDigit)
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		errs.Add(field, ErrPasswordSymbol)
+	}
+
+	if _, ok := p.breached[strings.ToLower(pwd)]; ok {
+		errs.Add(field, ErrPasswordBreached)
+	}
+}
+
+// ValidateName adds every rule the name fails to errs
+func (p *Policy) ValidateName(field, name string, errs *ValidationError) {
+	if p == nil {
+		return
+	}
+
+	length := utf8.RuneCountInString(name)
+	if length < p.cfg.NameMinLength {
+		errs.Add(field, ErrNameTooShort)
+	}
+	if length > p.cfg.NameMaxLength {
+		errs.Add(field, ErrNameTooLong)
+	}
+	if !p.namePattern.MatchString(name) {
+		errs.Add(field, ErrNameCharacters)
+	}
+}
+
+func (p *Policy) loadBreached(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open breached passwords: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read breached passwords: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+)
+
+func TestValidatePassword(t *testing.T) {
+	p, err := New(config.PolicyConfig{
+		PasswordMinLength: 10,
+		RequireUpper:      true,
+		RequireLower:      true,
+		RequireDigit:      true,
+		RequireSymbol:     true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	errs := &ValidationError{}
+	p.ValidatePassword("password", "abc", errs)
+	for _, expected := range []error{ErrPasswordTooShort, ErrPasswordUpper, ErrPasswordDigit, ErrPasswordSymbol} {
+		if !errors.Is(errs.Err(), expected) {
+			t.Fatalf("Expected error: %v, got: %v", expected, errs.Err())
+		}
+	}
+	if errors.Is(errs.Err(), ErrPasswordLower) {
+		t.Fatalf("Unexpected error: %v", ErrPasswordLower)
+	}
+	if len(errs.Fields) != 4 || errs.Fields[0].Field != "password" {
+		t.Fatalf("Expected 4 password errors, got: %v", errs.Fields)
+	}
+
+	errs = &ValidationError{}
+	p.ValidatePassword("password", "Correct-Horse-9", errs)
+	if err := errs.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidatePasswordBreached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := strings.Join([]string{"# known leaks", "", "Password123", "qwertyuiop"}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	p, err := New(config.PolicyConfig{BreachedPasswordsFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	errs := &ValidationError{}
+	p.ValidatePassword("password", "password123", errs)
+	if !errors.Is(errs.Err(), ErrPasswordBreached) {
+		t.Fatalf("Expected error: %v, got: %v", ErrPasswordBreached, errs.Err())
+	}
+
+	_, err = New(config.PolicyConfig{BreachedPasswordsFile: filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Fatalf("Expected error for a missing file")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	p, err := New(config.PolicyConfig{NameMaxLength: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	errs := &ValidationError{}
+	p.ValidateName("name", "<script>", errs)
+	if !errors.Is(errs.Err(), ErrNameTooLong) || !errors.Is(errs.Err(), ErrNameCharacters) {
+		t.Fatalf("Expected errors: %v and %v, got: %v", ErrNameTooLong, ErrNameCharacters, errs.Err())
+	}
+
+	errs = &ValidationError{}
+	p.ValidateName("name", "Zoë", errs)
+	if err := errs.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = New(config.PolicyConfig{NamePattern: "("})
+	if err == nil {
+		t.Fatalf("Expected error for an invalid pattern")
+	}
+}
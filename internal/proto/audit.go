@@ -0,0 +1,32 @@
+package proto
+
+var (
+	AuditAccountCreated         = "account.created"
+	AuditLoginSucceeded         = "login.succeeded"
+	AuditLoginFailed            = "login.failed"
+	AuditLoginRefused           = "login.refused"
+	AuditNonceRotated           = "nonce.rotated"
+	AuditAccountLocked          = "account.locked"
+	AuditIPLocked               = "ip.locked"
+	AuditAccountUnlock          = "account.unlocked"
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password.reset_requested"
+	AuditPasswordReset          = "password.reset"
+	AuditTOTPEnabled            = "totp.enabled"
+	AuditLogout                 = "logout"
+	AuditLogoutAll              = "logout.all"
+	AuditSessionRevoked         = "session.revoked"
+	AuditDeposit                = "transaction.deposit"
+	AuditWithdraw               = "transaction.withdraw"
+	AuditTransfer               = "transaction.transfer"
+)
+
+type AuditEvent struct {
+	Type        string       `json:"type"`
+	Account     string       `json:"account,omitempty"`
+	IP          string       `json:"ip,omitempty"`
+	Detail      string       `json:"detail,omitempty"`
+	RequestID   string       `json:"request_id,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	CreatedAt   int64        `json:"created_at"`
+}
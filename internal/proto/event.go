@@ -0,0 +1,19 @@
+package proto
+
+var (
+	// EventCredited - money arrived at the account
+	EventCredited = "transaction.credited"
+	// EventDebited - money left the account
+	EventDebited = "transaction.debited"
+)
+
+// Event - a change of an account, Balance is the balance of the account
+// once the transaction committed
+type Event struct {
+	ID          uint64      `json:"id"`
+	Type        string      `json:"type"`
+	Account     string      `json:"account"`
+	Balance     int         `json:"balance"`
+	Transaction Transaction `json:"transaction"`
+	CreatedAt   int64       `json:"created_at"`
+}
@@ -0,0 +1,8 @@
+package proto
+
+type Notification struct {
+	Account   string `json:"account"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	CreatedAt int64  `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package proto
+
+// OutboxMessage - an event of a committed transaction waiting to be
+// published, a message can be published more than once so consumers
+// deduplicate with its ID
+type OutboxMessage struct {
+	ID        uint64 `json:"id"`
+	Account   string `json:"account"`
+	Event     Event  `json:"event"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
@@ -25,8 +25,27 @@ type TransactionRequest struct {
 	From   string `json:"from"`
 	To     string `json:"to"`
 	Amount int    `json:"amount"`
+	TOTP   string `json:"totp"`
 }
 
 type TransactionResponse struct {
 	ID uint64 `json:"id"`
 }
+
+// DepositRequest - body of POST /v1/accounts/{id}/deposits
+type DepositRequest struct {
+	Amount int `json:"amount"`
+}
+
+// WithdrawalRequest - body of POST /v1/accounts/{id}/withdrawals
+type WithdrawalRequest struct {
+	Amount int    `json:"amount"`
+	TOTP   string `json:"totp"`
+}
+
+// TransferRequest - body of POST /v1/accounts/{id}/transfers
+type TransferRequest struct {
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+	TOTP   string `json:"totp"`
+}
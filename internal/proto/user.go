@@ -11,6 +11,7 @@ type User struct {
 }
 
 type UserToken struct {
+	ID        string `json:"jti"`
 	Account   string `json:"account"`
 	Nonce     string `json:"nonce"`
 	ExpireAt  int64  `json:"expire_at"`
@@ -20,6 +21,7 @@ type UserToken struct {
 type GetTokenRequest struct {
 	Account  string `json:"account"`
 	Password string `json:"password"`
+	TOTP     string `json:"totp"`
 }
 
 type CreateAccountRequest struct {
@@ -27,3 +29,37 @@ type CreateAccountRequest struct {
 	Name     string `json:"name"`
 	Balance  int    `json:"balance"`
 }
+
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type VerifyTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ResetPasswordRequest struct {
+	Account string `json:"account"`
+}
+
+type ConfirmResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type Session struct {
+	ID         string `json:"id"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	Current    bool   `json:"current"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	ExpireAt   int64  `json:"expire_at"`
+}
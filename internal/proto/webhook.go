@@ -0,0 +1,44 @@
+package proto
+
+var (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook - an URL notified of the events of an account, the secret is
+// only returned when the webhook is created
+type Webhook struct {
+	ID        string   `json:"id"`
+	Account   string   `json:"account"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// WebhookDelivery - the delivery of an event to a webhook
+type WebhookDelivery struct {
+	ID        string           `json:"id"`
+	WebhookID string           `json:"webhook_id"`
+	Event     Event            `json:"event"`
+	State     string           `json:"state"`
+	Attempts  []WebhookAttempt `json:"attempts"`
+	CreatedAt int64            `json:"created_at"`
+	UpdatedAt int64            `json:"updated_at"`
+}
+
+// WebhookAttempt - a single try of a delivery, StatusCode is zero when no
+// response arrived
+type WebhookAttempt struct {
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+	Duration   int64  `json:"duration_ms"`
+	CreatedAt  int64  `json:"created_at"`
+}
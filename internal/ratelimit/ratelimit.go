@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"github.com/0x726f6f6b6965/bank/internal/metrics"
+)
+
+const (
+	GroupAuth  = "auth"
+	GroupRead  = "read"
+	GroupWrite = "write"
+)
+
+var (
+	DefaultPeriod = time.Second
+	// DefaultPruneInterval is how often the memory store drops the full buckets
+	DefaultPruneInterval = time.Minute
+)
+
+// Rule - a token bucket refilled with Rate tokens per second up to Burst
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+// NewRule returns the bucket of the config, ok is false for a disabled rule
+func NewRule(cfg config.RateLimitRule) (Rule, bool) {
+	if cfg.Requests <= 0 {
+		return Rule{}, false
+	}
+	if cfg.Period <= 0 {
+		cfg.Period = DefaultPeriod
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.Requests
+	}
+	return Rule{
+		Rate:  float64(cfg.Requests) / cfg.Period.Seconds(),
+		Burst: cfg.Burst,
+	}, true
+}
+
+// Store keeps the buckets, a store shared by the instances of the service
+// makes them share the limits
+type Store interface {
+	// Take removes a token from the bucket of the key, it returns the wait
+	// for the next token when the bucket is empty
+	Take(ctx context.Context, key string, rule Rule, now time.Time) (retryAfter time.Duration, ok bool, err error)
+}
+
+// NewStore returns the store selected by the config, the memory store by default
+func NewStore(cfg config.RateLimitConfig) Store {
+	switch cfg.Store {
+	default:
+		return NewMemoryStore()
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will be full again, it can be dropped then
+	full time.Time
+}
+
+type memoryStore struct {
+	sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// NewMemoryStore returns a store keeping the buckets of this instance
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, rule Rule, now time.Time) (time.Duration, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed*rule.Rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return secondsToDuration((1 - b.tokens) / rule.Rate), false, nil
+	}
+	b.tokens--
+	b.full = now.Add(secondsToDuration((float64(rule.Burst) - b.tokens) / rule.Rate))
+	return 0, true, nil
+}
+
+// prune drops the buckets which are full again, the caller holds the lock
+func (s *memoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < DefaultPruneInterval {
+		return
+	}
+	s.lastPrune = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// Limiter applies the rules of the route groups
+type Limiter struct {
+	sync.RWMutex
+	store Store
+	clock clock.Clock
+	rules map[string]Rule
+}
+
+// New returns the limiter of the config, a group without a rule is not
+// limited. The buckets refill by the time of clk.
+func New(cfg config.RateLimitConfig, clk clock.Clock) *Limiter {
+	return NewWithStore(cfg, NewStore(cfg), clk)
+}
+
+// NewWithStore is New with the buckets kept in store
+func NewWithStore(cfg config.RateLimitConfig, store Store, clk clock.Clock) *Limiter {
+	l := &Limiter{store: store, clock: clock.Or(clk)}
+	l.Update(cfg)
+	return l
+}
+
+// Update replaces the rules, the buckets and the store are kept
+func (l *Limiter) Update(cfg config.RateLimitConfig) {
+	rules := make(map[string]Rule)
+	for group, rule := range map[string]config.RateLimitRule{
+		GroupAuth:  cfg.Auth,
+		GroupRead:  cfg.Read,
+		GroupWrite: cfg.Write,
+	} {
+		if r, ok := NewRule(rule); ok {
+			rules[group] = r
+		}
+	}
+	l.Lock()
+	l.rules = rules
+	l.Unlock()
+}
+
+// Allow takes a token of the group from the bucket of every key, it returns
+// the longest wait when one of them is empty. A failing store lets the
+// request through.
+func (l *Limiter) Allow(ctx context.Context, group string, keys ...string) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	l.RLock()
+	rule, ok := l.rules[group]
+	l.RUnlock()
+	if !ok {
+		return 0, true
+	}
+	now := l.clock.Now()
+	var wait time.Duration
+	allowed := true
+	for _, key := range keys {
+		retryAfter, ok, err := l.store.Take(ctx, group+":"+key, rule, now)
+		if err != nil {
+			slog.WarnContext(ctx, "rate limit store failed", "group", group, "error", err)
+			continue
+		}
+		if !ok {
+			allowed = false
+			wait = max(wait, retryAfter)
+		}
+	}
+	if !allowed {
+		metrics.RateLimited.WithLabelValues(group).Inc()
+	}
+	return wait, allowed
+}
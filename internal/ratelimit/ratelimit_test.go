@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0x726f6f6b6965/bank/internal/clock"
+	"github.com/0x726f6f6b6965/bank/internal/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	rule := Rule{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, ok, _ := store.Take(context.Background(), "k", rule, now); !ok {
+			t.Fatalf("Expected request %d to pass", i+1)
+		}
+	}
+	wait, ok, _ := store.Take(context.Background(), "k", rule, now)
+	if ok || wait != time.Second {
+		t.Fatalf("Expected a wait: %v, got: %v, %v", time.Second, wait, ok)
+	}
+	if _, ok, _ := store.Take(context.Background(), "other", rule, now); !ok {
+		t.Fatalf("Expected the buckets of the keys to be separate")
+	}
+
+	// the bucket refills with the rate
+	if _, ok, _ := store.Take(context.Background(), "k", rule, now.Add(time.Second)); !ok {
+		t.Fatalf("Expected a token after a second")
+	}
+	wait, ok, _ = store.Take(context.Background(), "k", rule, now.Add(1500*time.Millisecond))
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Expected a wait: %v, got: %v, %v", 500*time.Millisecond, wait, ok)
+	}
+}
+
+func TestNewRule(t *testing.T) {
+	if _, ok := NewRule(config.RateLimitRule{}); ok {
+		t.Fatalf("Expected a rule without requests to be disabled")
+	}
+	rule, ok := NewRule(config.RateLimitRule{Requests: 30, Period: time.Minute})
+	if !ok || rule.Rate != 0.5 || rule.Burst != 30 {
+		t.Fatalf("Expected rule: %v, got: %v", Rule{Rate: 0.5, Burst: 30}, rule)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	limiter := New(config.RateLimitConfig{
+		Write: config.RateLimitRule{Requests: 1, Period: time.Hour},
+	}, clk)
+	ctx := context.Background()
+
+	if _, ok := limiter.Allow(ctx, GroupWrite, "ip:1", "account:a"); !ok {
+		t.Fatalf("Expected the first request to pass")
+	}
+	// the account is limited from any IP
+	clk.Advance(time.Minute)
+	wait, ok := limiter.Allow(ctx, GroupWrite, "ip:2", "account:a")
+	if ok || wait != 59*time.Minute {
+		t.Fatalf("Expected the account to be limited for: %v, got: %v, %v", 59*time.Minute, wait, ok)
+	}
+	// and the IP for any account
+	if _, ok := limiter.Allow(ctx, GroupWrite, "ip:1", "account:b"); ok {
+		t.Fatalf("Expected the IP to be limited")
+	}
+	// a group without a rule is not limited
+	for i := 0; i < 3; i++ {
+		if _, ok := limiter.Allow(ctx, GroupRead, "ip:1", "account:a"); !ok {
+			t.Fatalf("Expected the read group not to be limited")
+		}
+	}
+
+	// the buckets refill by the time of the clock
+	clk.Advance(59 * time.Minute)
+	if _, ok := limiter.Allow(ctx, GroupWrite, "ip:3", "account:a"); !ok {
+		t.Fatalf("Expected a token after the period")
+	}
+}
+
+func TestLimiterUpdate(t *testing.T) {
+	limiter := New(config.RateLimitConfig{}, nil)
+	ctx := context.Background()
+
+	limiter.Update(config.RateLimitConfig{
+		Read: config.RateLimitRule{Requests: 1, Period: time.Hour},
+	})
+	if _, ok := limiter.Allow(ctx, GroupRead, "ip:1"); !ok {
+		t.Fatalf("Expected the first request to pass")
+	}
+	if _, ok := limiter.Allow(ctx, GroupRead, "ip:1"); ok {
+		t.Fatalf("Expected the new rule to limit the group")
+	}
+
+	limiter.Update(config.RateLimitConfig{})
+	if _, ok := limiter.Allow(ctx, GroupRead, "ip:1"); !ok {
+		t.Fatalf("Expected the removed rule not to limit the group")
+	}
+}
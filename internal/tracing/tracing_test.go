@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/0x726f6f6b6965/bank/internal/config"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	// disabled by default
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "test")
+	if span.SpanContext().IsValid() {
+		t.Fatalf("Expected no span to be recorded")
+	}
+
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"}); !errors.Is(err, ErrExporter) {
+		t.Fatalf("Expected error: %v, got: %v", ErrExporter, err)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider, err := NewProvider(config.TracingConfig{ServiceName: "test"}, sdktrace.WithSpanProcessor(recorder))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	defer provider.Shutdown(context.Background())
+
+	// a trace of a caller is continued
+	ctx := Propagator().Extract(context.Background(), headerCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	_, span := provider.Tracer("test").Start(ctx, "test")
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected spans: %v, got: %v", 1, len(spans))
+	}
+	if id := spans[0].SpanContext().TraceID().String(); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Expected trace: %v, got: %v", "4bf92f3577b34da6a3ce929d0e0e4736", id)
+	}
+	if spans[0].Parent().SpanID() != trace.SpanID([8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}) {
+		t.Fatalf("Expected parent: %v, got: %v", "00f067aa0ba902b7", spans[0].Parent().SpanID())
+	}
+	for _, attr := range spans[0].Resource().Attributes() {
+		if attr.Key == "service.name" && attr.Value.AsString() != "test" {
+			t.Fatalf("Expected service: %v, got: %v", "test", attr.Value.AsString())
+		}
+	}
+}
+
+type headerCarrier map[string]string
+
+func (c headerCarrier) Get(key string) string { return c[key] }
+
+func (c headerCarrier) Set(key, value string) { c[key] = value }
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
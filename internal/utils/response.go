@@ -15,3 +15,15 @@ func Response(ctx *gin.Context, code int, errCode int, errMsg string, data inter
 		"data":        data,
 	})
 }
+
+// ErrorResponse - Unified error response, it aborts the request with the
+// same format as Response plus the machine readable error code
+func ErrorResponse(ctx *gin.Context, code int, errCode string, errMsg string, data interface{}) {
+	ctx.AbortWithStatusJSON(code, map[string]interface{}{
+		"code":        code,
+		"error":       errCode,
+		"currentTime": time.Now().UnixMilli(),
+		"message":     errMsg,
+		"data":        data,
+	})
+}
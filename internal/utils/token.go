@@ -15,22 +15,31 @@ var (
 	ErrTokenExpire = errors.New("the token expired")
 )
 
-// GenerateNewAccessToken generates a new JWT token
-func GenerateNewAccessToken(account, nonce string, expire time.Duration) (string, error) {
+// GenerateNewAccessToken generates a new JWT token with the ID id issued at
+// now, it returns the signed token and its metadata
+func GenerateNewAccessToken(id, account, nonce string, now time.Time, expire time.Duration) (string, *proto.UserToken, error) {
+
+	metadata := &proto.UserToken{
+		ID:        id,
+		Account:   account,
+		Nonce:     nonce,
+		ExpireAt:  now.Add(expire).Unix(),
+		CreatedAt: now.Unix(),
+	}
 
 	// create a JWT claim
 	claims := jwt.MapClaims{}
 
-	now := time.Now()
-
+	// assign an unique id for the token
+	claims["jti"] = metadata.ID
 	// assign an expiration time for the token
-	claims["expire_at"] = now.Add(expire).Unix()
+	claims["expire_at"] = metadata.ExpireAt
 	// assign a data for user
-	claims["user"] = account
+	claims["user"] = metadata.Account
 	// assign nonce
-	claims["nonce"] = nonce
+	claims["nonce"] = metadata.Nonce
 	// assign a created at time
-	claims["created_at"] = now.Unix()
+	claims["created_at"] = metadata.CreatedAt
 
 	// create a JWT token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -40,16 +49,21 @@ func GenerateNewAccessToken(account, nonce string, expire time.Duration) (string
 
 	// if conversion is failed, return an error
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	// return the generated JWT token
-	return t, nil
+	return t, metadata, nil
 }
 
 // ExtractTokenMetadata extracts JWT token metadata
 func ExtractTokenMetadata(r *http.Request) (*proto.UserToken, error) {
+	return extractTokenMetadata(extractToken(r))
+}
+
+// extractTokenMetadata extracts the metadata of the signed token
+func extractTokenMetadata(tokenString string) (*proto.UserToken, error) {
 	// verify the JWT token
-	token, err := verifyToken(r)
+	token, err := verifyToken(tokenString)
 
 	// if verification is failed, return an error
 	if err != nil {
@@ -72,9 +86,12 @@ func ExtractTokenMetadata(r *http.Request) (*proto.UserToken, error) {
 		nonce := claims["nonce"].(string)
 		// set created at for the token
 		createdAt := int64(claims["created_at"].(float64))
+		// set id for the token, tokens issued before it existed have none
+		id, _ := claims["jti"].(string)
 
 		// return the JWT token metadata
 		return &proto.UserToken{
+			ID:        id,
 			ExpireAt:  expires,
 			Account:   account,
 			Nonce:     nonce,
@@ -86,13 +103,16 @@ func ExtractTokenMetadata(r *http.Request) (*proto.UserToken, error) {
 	return nil, err
 }
 
-// CheckToken checks JWT token
-func CheckToken(r *http.Request) (*proto.UserToken, error) {
-	// get the current time
-	var now int64 = time.Now().Unix()
+// CheckToken checks JWT token at the time now
+func CheckToken(r *http.Request, now time.Time) (*proto.UserToken, error) {
+	return CheckTokenString(extractToken(r), now)
+}
 
+// CheckTokenString checks the signed JWT token at the time now, for the
+// transports which do not carry it in an http request
+func CheckTokenString(tokenString string, now time.Time) (*proto.UserToken, error) {
 	// extract the JWT token metadata
-	claims, err := ExtractTokenMetadata(r)
+	claims, err := extractTokenMetadata(tokenString)
 	// if extraction is failed, return an error
 	if err != nil {
 		return nil, err
@@ -102,7 +122,7 @@ func CheckToken(r *http.Request) (*proto.UserToken, error) {
 	var expires int64 = claims.ExpireAt
 
 	// if the token is expired, return an error
-	if now > expires {
+	if now.Unix() > expires {
 		return nil, ErrTokenExpire
 	}
 
@@ -111,10 +131,7 @@ func CheckToken(r *http.Request) (*proto.UserToken, error) {
 }
 
 // verifyToken verifies JWT token
-func verifyToken(r *http.Request) (*jwt.Token, error) {
-	// get the token
-	var tokenString string = extractToken(r)
-
+func verifyToken(tokenString string) (*jwt.Token, error) {
 	// parse the JWT token
 	token, err := jwt.Parse(tokenString, jwtKeyFunc)
 
@@ -129,8 +146,11 @@ func verifyToken(r *http.Request) (*jwt.Token, error) {
 
 // extractToken extracts JWT token from the Authorization header
 func extractToken(r *http.Request) string {
-	// get the Authorization header
-	var header string = r.Header.Get("Authorization")
+	return BearerToken(r.Header.Get("Authorization"))
+}
+
+// BearerToken extracts JWT token from the value of an Authorization header
+func BearerToken(header string) string {
 	// split the content inside the header to get the JWT token
 	token := strings.Split(header, " ")
 
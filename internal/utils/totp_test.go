@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range cases {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if code != expected {
+			t.Fatalf("Expected code at %d: %v, got: %v", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now()
+	code, err := TOTPCode(secret, TOTPStep(now.Add(-TOTPPeriod*time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, ok := ValidateTOTP(secret, code, now, 1); !ok {
+		t.Fatalf("Expected code %v to be valid", code)
+	}
+	if _, ok := ValidateTOTP(secret, code, now, 0); ok {
+		t.Fatalf("Expected code %v to be invalid without skew", code)
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 1); ok {
+		t.Fatalf("Expected short code to be invalid")
+	}
+
+	uri := TOTPURI("bank", "test", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/bank:test?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("Unexpected uri: %v", uri)
+	}
+}